@@ -0,0 +1,326 @@
+package models
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrAuditTampered = errors.New("audit log is tampered")
+
+// AuditAction is a security-relevant action.
+type AuditAction string
+
+const (
+	AuditTokenUpdate    AuditAction = "token.update"
+	AuditPasswordChange AuditAction = "password.change"
+	AuditPlanChange     AuditAction = "plan.change"
+	AuditClaimsExchange AuditAction = "claims.exchange"
+	AuditAccountLink    AuditAction = "account.link"
+	AuditAccountUnlink  AuditAction = "account.unlink"
+)
+
+// AuditEvent is an append-only record of AuditLog.
+// Hashは直前のイベントのHashを含めて計算するため、途中の改ざん・削除はVerifyAuditChainで検出できる
+type AuditEvent struct {
+	Seq int64 `firestore:"seq" json:"seq"`
+
+	// AccountID is Twitter/X AccountID of the target.
+	AccountID string      `firestore:"account_id,omitempty" json:"account_id,omitempty"`
+	Actor     string      `firestore:"actor,omitempty" json:"actor,omitempty"`
+	Action    AuditAction `firestore:"action" json:"action"`
+	// Target is a document path, e.g. accounts/{id}
+	Target    string `firestore:"target,omitempty" json:"target,omitempty"`
+	IP        string `firestore:"ip,omitempty" json:"ip,omitempty"`
+	UserAgent string `firestore:"user_agent,omitempty" json:"user_agent,omitempty"`
+
+	// BeforeHash, AfterHash are hashes of the target document, secrets are not stored in the log.
+	BeforeHash string `firestore:"before_hash,omitempty" json:"before_hash,omitempty"`
+	AfterHash  string `firestore:"after_hash,omitempty" json:"after_hash,omitempty"`
+
+	PrevHash string `firestore:"prev_hash,omitempty" json:"prev_hash,omitempty"`
+	Hash     string `firestore:"hash" json:"hash"`
+
+	CreatedAt time.Time `firestore:"created_at" json:"created_at"`
+}
+
+// NewAuditEvent is constructor
+// before, afterは変更前後のドキュメント、無い場合はnil
+func NewAuditEvent(action AuditAction, actor, accountID, target string, before, after any) AuditEvent {
+	return AuditEvent{
+		AccountID:  accountID,
+		Actor:      actor,
+		Action:     action,
+		Target:     target,
+		BeforeHash: AuditHash(before),
+		AfterHash:  AuditHash(after),
+	}
+}
+
+// AuditHash ドキュメントのハッシュを返します、nilは空文字
+func AuditHash(v any) string {
+	if v == nil {
+		return ""
+	}
+	data, err := json.Marshal(documentValue(reflect.ValueOf(v), false))
+	if err != nil {
+		return ""
+	}
+	return hashFields(string(data))
+}
+
+// auditTimePrecision Firestoreが保持する時刻の精度
+// why: ナノ秒を含めてハッシュすると、Firestoreから読み込んだイベントの検証が失敗するため
+const auditTimePrecision = time.Microsecond
+
+// chainHash 直前のハッシュとイベントの内容からハッシュを計算します
+func (p AuditEvent) chainHash() string {
+	return hashFields(
+		strconv.FormatInt(p.Seq, 10),
+		p.AccountID,
+		p.Actor,
+		string(p.Action),
+		p.Target,
+		p.IP,
+		p.UserAgent,
+		p.BeforeHash,
+		p.AfterHash,
+		p.PrevHash,
+		p.CreatedAt.UTC().Truncate(auditTimePrecision).Format(time.RFC3339Nano),
+	)
+}
+
+// VerifyAuditChain Seq順のイベントのハッシュの連鎖を確認します
+// 期間を指定して取得したイベントでも、先頭のPrevHashは確認しない
+// アカウントを指定して取得したイベントは連鎖が途切れるため、全アカウント分で確認すること
+func VerifyAuditChain(events []AuditEvent) error {
+	for i, v := range events {
+		if v.Hash != v.chainHash() {
+			return fmt.Errorf("%w: seq %d hash mismatch", ErrAuditTampered, v.Seq)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := events[i-1]
+		if v.Seq != prev.Seq+1 || v.PrevHash != prev.Hash {
+			return fmt.Errorf("%w: seq %d does not follow seq %d", ErrAuditTampered, v.Seq, prev.Seq)
+		}
+	}
+	return nil
+}
+
+// AuditSink stores AuditEvent.
+type AuditSink interface {
+	Append(ctx context.Context, event AuditEvent) error
+}
+
+// AuditQuerier is implemented by AuditSink that can read events.
+type AuditQuerier interface {
+	// Query accountIDが空の場合は全てのアカウント、from, toのゼロ値は無制限。Seq順で返す
+	Query(ctx context.Context, accountID string, from, to time.Time) ([]AuditEvent, error)
+}
+
+// AuditLog writes hash-chained AuditEvent to AuditSink.
+// ハッシュの連鎖は1つのAuditLogで直列に書き込む前提、複数プロセスから書き込む場合はSinkを分けること
+type AuditLog struct {
+	Sink AuditSink
+
+	mu   sync.Mutex
+	last AuditEvent
+	now  func() time.Time
+}
+
+// NewAuditLog is constructor
+// lastは既存のログの最後のイベント、新規の場合はゼロ値
+func NewAuditLog(sink AuditSink, last AuditEvent) *AuditLog {
+	return &AuditLog{
+		Sink: sink,
+		last: last,
+		now:  time.Now,
+	}
+}
+
+// Record イベントにSeq, PrevHash, Hashを設定して書き込みます
+// CreatedAtがゼロ値の場合は現在時刻
+func (p *AuditLog) Record(ctx context.Context, event AuditEvent) (AuditEvent, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	event.Seq = p.last.Seq + 1
+	event.PrevHash = p.last.Hash
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = p.now()
+	}
+	event.CreatedAt = event.CreatedAt.UTC().Truncate(auditTimePrecision)
+	event.Hash = event.chainHash()
+
+	if err := p.Sink.Append(ctx, event); err != nil {
+		return event, fmt.Errorf("error writing audit log: %v", err)
+	}
+	p.last = event
+
+	return event, nil
+}
+
+// StoreAuditSink stores events in a collection of Store, e.g. Firestore.
+// キーはSeqを0埋めした文字列、最後のイベントはHeadCollectionにも保存する
+type StoreAuditSink struct {
+	Store      Store
+	Collection string
+	// HeadCollection is collection name for the last event, Collection + "_head" if empty.
+	HeadCollection string
+}
+
+// auditHeadKey 最後のイベントのキー
+const auditHeadKey = "head"
+
+func (p StoreAuditSink) headCollection() string {
+	if p.HeadCollection == "" {
+		return p.Collection + "_head"
+	}
+	return p.HeadCollection
+}
+
+// Append for interface
+// 同じSeqのイベントは上書きせず、ErrAlreadyExistsを返す
+func (p StoreAuditSink) Append(ctx context.Context, event AuditEvent) error {
+	if err := CreateDocument(ctx, p.Store, p.Collection, fmt.Sprintf("%016d", event.Seq), event); err != nil {
+		return err
+	}
+
+	var head AuditEvent
+	return UpdateDocument(ctx, p.Store, p.headCollection(), auditHeadKey, &head, func(exists bool) error {
+		if exists && head.Seq >= event.Seq {
+			return nil
+		}
+		head = event
+		return nil
+	})
+}
+
+// Query for interface
+func (p StoreAuditSink) Query(ctx context.Context, accountID string, from, to time.Time) ([]AuditEvent, error) {
+	var filters []Filter
+	if accountID != "" {
+		filters = append(filters, Where("account_id", accountID))
+	}
+
+	events := []AuditEvent{}
+	err := Each(ctx, p.Store, p.Collection, filters, func(v AuditEvent) error {
+		if inRange(v.CreatedAt, from, to) {
+			events = append(events, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].Seq < events[j].Seq })
+	return events, nil
+}
+
+// Last 最後のイベントを返します、NewAuditLogに渡して連鎖を再開する
+// HeadCollectionが無いログは、全てのイベントから探す
+func (p StoreAuditSink) Last(ctx context.Context) (AuditEvent, error) {
+	var last AuditEvent
+	err := p.Store.Get(ctx, p.headCollection(), auditHeadKey, &last)
+	if !errors.Is(err, ErrNotFound) {
+		return last, err
+	}
+
+	err = Each(ctx, p.Store, p.Collection, nil, func(v AuditEvent) error {
+		if v.Seq > last.Seq {
+			last = v
+		}
+		return nil
+	})
+	return last, err
+}
+
+// WriterAuditSink writes events as JSON Lines, for file and stdout.
+type WriterAuditSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterAuditSink is constructor
+func NewWriterAuditSink(w io.Writer) *WriterAuditSink {
+	return &WriterAuditSink{w: w}
+}
+
+// NewStdoutAuditSink 標準出力に書き出します
+func NewStdoutAuditSink() *WriterAuditSink {
+	return NewWriterAuditSink(os.Stdout)
+}
+
+// NewFileAuditSink ファイルに追記します、呼び出し側でファイルをCloseすること
+func NewFileAuditSink(path string) (*WriterAuditSink, *os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening audit log: %v", err)
+	}
+	return NewWriterAuditSink(f), f, nil
+}
+
+// Append for interface
+func (p *WriterAuditSink) Append(ctx context.Context, event AuditEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err = p.w.Write(append(data, '\n'))
+	return err
+}
+
+// ReadAuditLog JSON Linesのログからイベントを読み込みます
+// accountIDが空の場合は全てのアカウント、from, toのゼロ値は無制限
+func ReadAuditLog(r io.Reader, accountID string, from, to time.Time) ([]AuditEvent, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	events := []AuditEvent{}
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var v AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &v); err != nil {
+			return nil, fmt.Errorf("error reading audit log line %d: %v", line, err)
+		}
+		if accountID != "" && v.AccountID != accountID {
+			continue
+		}
+		if !inRange(v.CreatedAt, from, to) {
+			continue
+		}
+		events = append(events, v)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading audit log: %v", err)
+	}
+	return events, nil
+}
+
+// inRange from <= t < to, ゼロ値は無制限
+func inRange(t, from, to time.Time) bool {
+	if !from.IsZero() && t.Before(from) {
+		return false
+	}
+	if !to.IsZero() && !t.Before(to) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreAuditSink(t *testing.T) {
+	ctx := context.Background()
+	sink := StoreAuditSink{Store: NewMemoryStore(), Collection: "audits"}
+
+	last, err := sink.Last(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, last.Seq)
+
+	log := NewAuditLog(sink, last)
+	for i := 0; i < 3; i++ {
+		_, err := log.Record(ctx, NewAuditEvent(AuditPlanChange, "test", "acct", "subscribes/acct", nil, nil))
+		require.NoError(t, err)
+	}
+
+	last, err = sink.Last(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), last.Seq)
+
+	// 同じSeqは上書きしない
+	forged := last
+	forged.Actor = "forged"
+	assert.ErrorIs(t, sink.Append(ctx, forged), ErrAlreadyExists)
+
+	events, err := sink.Query(ctx, "", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, "test", events[2].Actor)
+	assert.NoError(t, VerifyAuditChain(events))
+}
@@ -0,0 +1,410 @@
+package models
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrNoBackup = errors.New("no backup at the time")
+
+// BackupVersion is a version of the archive format.
+const BackupVersion = 1
+
+// backupTimeLayout is a timestamp in archive name, sortable as string.
+const backupTimeLayout = "20060102T150405Z"
+
+// BackupModel is a collection to backup, with the type of its documents.
+// 復元時にドキュメントの型が必要なため、コレクションごとに型を登録する
+type BackupModel struct {
+	Collection string
+	// AccountField is a firestore tag name of Twitter/X AccountID, used for per-account restore.
+	// 空の場合はアカウント指定の復元の対象外
+	AccountField string
+	// Parent is a parent collection of subcollection, e.g. posts for posts/{id}/versions.
+	// 親コレクションのドキュメントごとにCollectionを書き出す
+	Parent string
+
+	typ reflect.Type
+}
+
+// NewBackupModel is constructor
+func NewBackupModel[T any](colName, accountField string) BackupModel {
+	return BackupModel{
+		Collection:   colName,
+		AccountField: accountField,
+		typ:          reflect.TypeOf((*T)(nil)).Elem(),
+	}
+}
+
+// NewBackupSubModel is constructor for subcollection, parent/{id}/colName
+func NewBackupSubModel[T any](parent, colName, accountField string) BackupModel {
+	m := NewBackupModel[T](colName, accountField)
+	m.Parent = parent
+	return m
+}
+
+// Name マニフェスト、RestoreOptions.Collectionsで使用する名前を返します
+// サブコレクションは親のキーを*とする、e.g. posts/*/versions
+func (p BackupModel) Name() string {
+	if p.Parent == "" {
+		return p.Collection
+	}
+	return p.Parent + "/*/" + p.Collection
+}
+
+// backupModelName ドキュメントのコレクションからBackupModel.Nameを返します
+func backupModelName(colName string) string {
+	parts := strings.Split(colName, "/")
+	if len(parts) == 3 {
+		return parts[0] + "/*/" + parts[2]
+	}
+	return colName
+}
+
+// DefaultBackupModels モデルが使用する全てのコレクションを返します
+// コレクション名は既定の名前、異なる場合はNewBackupModel, NewBackupSubModelで作成すること
+//
+//	sync_states: SheetSync, SheetImporterのStateCollection
+//	posts/*/versions, posts_versions: Versioned[Post]
+//	audits, audits_head: StoreAuditSinkのCollection, HeadCollection
+//	plan_catalogs: LoadPlanCatalogs, SavePlanCatalog
+//	usages: UsageRecorder
+//	billing_events: BillingProcessorのEventCollection
+//	x_rate_limits: XRateLimitStore
+func DefaultBackupModels() []BackupModel {
+	return []BackupModel{
+		NewBackupModel[Account]("accounts", "id"),
+		NewBackupModel[Post]("posts", "id"),
+		NewBackupModel[Schedule]("schedules", "owner_id"),
+		NewBackupModel[Rule]("rules", "owner_id"),
+		NewBackupModel[Subscribe]("subscribes", "id"),
+		NewBackupModel[Claims]("claims", "id"),
+		NewBackupModel[Group]("groups", "owner_id"),
+		NewBackupModel[UsageRollup]("usages", "account_id"),
+		NewBackupModel[BillingRecord]("billing_events", "account_id"),
+		NewBackupModel[XRateLimit]("x_rate_limits", "account_id"),
+		NewBackupModel[AuditEvent]("audits", "account_id"),
+		// 複数のアカウントに属するため、アカウント単位では復元しない
+		NewBackupModel[User]("users", ""),
+		NewBackupModel[Workspace]("workspaces", ""),
+		// アカウントのフィールドが無い、または全体で共有する
+		NewBackupModel[SyncState]("sync_states", ""),
+		NewBackupSubModel[Version[Post]]("posts", VersionCollection, ""),
+		NewBackupModel[VersionHead]("posts_"+VersionCollection, ""),
+		NewBackupModel[AuditEvent]("audits_head", ""),
+		NewBackupModel[PlanCatalog]("plan_catalogs", ""),
+	}
+}
+
+// BackupManifest is the first line of an archive.
+type BackupManifest struct {
+	Version     int       `json:"version"`
+	Collections []string  `json:"collections"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// backupRecord is a line of an archive, a document of a collection.
+type backupRecord struct {
+	Collection string          `json:"collection"`
+	Key        string          `json:"key"`
+	Doc        json.RawMessage `json:"doc"`
+}
+
+// BackupName アーカイブのファイル名を返します
+// 名前順が作成日時順となる、e.g. backup-20240102T030405Z.jsonl.gz
+func BackupName(prefix string, t time.Time) string {
+	return fmt.Sprintf("%s-%s.jsonl.gz", prefix, t.UTC().Format(backupTimeLayout))
+}
+
+// parseBackupName ファイル名から作成日時を返します
+func parseBackupName(prefix, name string) (time.Time, bool) {
+	s, ok := strings.CutPrefix(path.Base(name), prefix+"-")
+	if !ok {
+		return time.Time{}, false
+	}
+	s, ok = strings.CutSuffix(s, ".jsonl.gz")
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(backupTimeLayout, s)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// SelectBackup 指定日時以前で最新のアーカイブ名を返します
+// 該当するアーカイブが無い場合はErrNoBackupを返す
+func SelectBackup(fsys fs.FS, prefix string, at time.Time) (string, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return "", fmt.Errorf("error reading backups: %v", err)
+	}
+
+	names := []string{}
+	for _, v := range entries {
+		t, ok := parseBackupName(prefix, v.Name())
+		if !ok || v.IsDir() || t.After(at) {
+			continue
+		}
+		names = append(names, v.Name())
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("%w: %s", ErrNoBackup, at.Format(time.RFC3339))
+	}
+
+	sort.Strings(names)
+	return names[len(names)-1], nil
+}
+
+// Backup 全てのコレクションをgzip圧縮したJSON Linesとして書き出します
+// 1行目はBackupManifest、以降は1行1ドキュメント。秘匿情報もそのまま含むため保管場所に注意すること
+// 全件をメモリに読み込まないよう、StoreはIteratorを実装している必要がある
+func Backup(ctx context.Context, store Store, models []BackupModel, w io.Writer, now time.Time) (map[string]int, error) {
+	it, ok := store.(Iterator)
+	if !ok {
+		return nil, fmt.Errorf("error backup: store does not implement Iterator, store type: %T", store)
+	}
+
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+	enc.SetEscapeHTML(false)
+
+	manifest := BackupManifest{Version: BackupVersion, CreatedAt: now}
+	for _, m := range models {
+		manifest.Collections = append(manifest.Collections, m.Name())
+	}
+	if err := enc.Encode(manifest); err != nil {
+		return nil, fmt.Errorf("error writing manifest: %v", err)
+	}
+
+	counts := make(map[string]int, len(models))
+	write := func(m BackupModel, colName string) error {
+		return it.Iterate(ctx, colName, nil, func(key string, decode func(data any) error) error {
+			doc := reflect.New(m.typ)
+			if err := decode(doc.Interface()); err != nil {
+				return fmt.Errorf("error reading %s/%s: %v", colName, key, err)
+			}
+
+			data, err := json.Marshal(documentValue(doc.Elem(), false))
+			if err != nil {
+				return fmt.Errorf("error encoding %s/%s: %v", colName, key, err)
+			}
+			if err := enc.Encode(backupRecord{Collection: colName, Key: key, Doc: data}); err != nil {
+				return err
+			}
+			counts[m.Name()]++
+			return nil
+		})
+	}
+	for _, m := range models {
+		if m.Parent == "" {
+			if err := write(m, m.Collection); err != nil {
+				return counts, err
+			}
+			continue
+		}
+
+		// 親のキーを先に集め、親の読み込み中に別のコレクションを読み込まない
+		var parents []string
+		err := it.Iterate(ctx, m.Parent, nil, func(key string, decode func(data any) error) error {
+			parents = append(parents, key)
+			return nil
+		})
+		if err != nil {
+			return counts, err
+		}
+		for _, key := range parents {
+			if err := write(m, m.Parent+"/"+key+"/"+m.Collection); err != nil {
+				return counts, err
+			}
+		}
+	}
+
+	return counts, zw.Close()
+}
+
+// RestoreOptions is options of Restore.
+type RestoreOptions struct {
+	// Collections is BackupModel.Name to restore, all collections in the archive if empty.
+	Collections []string
+	// AccountID restores only documents of the account.
+	// AccountFieldの無いコレクションは復元しない
+	AccountID string
+	// DryRun counts documents without writing.
+	DryRun bool
+}
+
+// Restore アーカイブのドキュメントをStoreに書き込みます
+// 同じキーのドキュメントは上書きする。Firestoreエミュレータへの復元はFIRESTORE_EMULATOR_HOSTを設定したClientForFirestoreを使用する
+func Restore(ctx context.Context, r io.Reader, store Store, models []BackupModel, opts RestoreOptions) (*BackupManifest, map[string]int, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading archive: %v", err)
+	}
+	defer zr.Close()
+
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, nil, fmt.Errorf("error reading manifest: %v", err)
+		}
+		return nil, nil, errors.New("error reading manifest: empty archive")
+	}
+	manifest := &BackupManifest{}
+	if err := json.Unmarshal(scanner.Bytes(), manifest); err != nil {
+		return nil, nil, fmt.Errorf("error reading manifest: %v", err)
+	}
+	if manifest.Version != BackupVersion {
+		return manifest, nil, fmt.Errorf("error reading manifest: unsupported version %d", manifest.Version)
+	}
+
+	byName := make(map[string]BackupModel, len(models))
+	for _, m := range models {
+		byName[m.Name()] = m
+	}
+	selected := func(colName string) bool {
+		if len(opts.Collections) == 0 {
+			return true
+		}
+		for _, v := range opts.Collections {
+			if v == colName {
+				return true
+			}
+		}
+		return false
+	}
+
+	counts := make(map[string]int)
+	for line := 2; scanner.Scan(); line++ {
+		if err := ctx.Err(); err != nil {
+			return manifest, counts, err
+		}
+
+		var rec backupRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return manifest, counts, fmt.Errorf("error reading line %d: %v", line, err)
+		}
+		name := backupModelName(rec.Collection)
+		if !selected(name) {
+			continue
+		}
+		m, ok := byName[name]
+		if !ok {
+			return manifest, counts, fmt.Errorf("error reading line %d: unknown collection %s", line, rec.Collection)
+		}
+
+		doc := reflect.New(m.typ)
+		if err := decodeDocument(rec.Doc, doc.Elem()); err != nil {
+			return manifest, counts, fmt.Errorf("error decoding %s/%s: %v", rec.Collection, rec.Key, err)
+		}
+
+		if opts.AccountID != "" {
+			if m.AccountField == "" {
+				continue
+			}
+			field, ok := fieldByTag(doc, m.AccountField)
+			if !ok || field.Kind() != reflect.String || field.String() != opts.AccountID {
+				continue
+			}
+		}
+
+		if !opts.DryRun {
+			if err := store.Set(ctx, rec.Collection, rec.Key, doc.Elem().Interface()); err != nil {
+				return manifest, counts, err
+			}
+		}
+		counts[name]++
+	}
+	if err := scanner.Err(); err != nil {
+		return manifest, counts, fmt.Errorf("error reading archive: %v", err)
+	}
+
+	return manifest, counts, nil
+}
+
+var (
+	rawMessageType      = reflect.TypeOf(json.RawMessage{})
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// decodeDocument documentValueで変換した値を構造体に戻します
+func decodeDocument(data json.RawMessage, v reflect.Value) error {
+	if len(data) == 0 || string(data) == "null" {
+		return nil
+	}
+	if v.Type() == timeType || v.Addr().Type().Implements(jsonUnmarshalerType) {
+		return json.Unmarshal(data, v.Addr().Interface())
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		v.Set(reflect.New(v.Type().Elem()))
+		return decodeDocument(data, v.Elem())
+	case reflect.Struct:
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			raw, ok := m[exportName(f)]
+			if !ok {
+				continue
+			}
+			if err := decodeDocument(raw, v.Field(i)); err != nil {
+				return fmt.Errorf("%s: %v", exportName(f), err)
+			}
+		}
+		return nil
+	case reflect.Slice, reflect.Array:
+		var list []json.RawMessage
+		if err := json.Unmarshal(data, &list); err != nil {
+			return err
+		}
+		if v.Kind() == reflect.Slice {
+			v.Set(reflect.MakeSlice(v.Type(), len(list), len(list)))
+		}
+		for i := 0; i < len(list) && i < v.Len(); i++ {
+			if err := decodeDocument(list[i], v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		// キーの変換はencoding/jsonに任せる
+		raw := reflect.New(reflect.MapOf(v.Type().Key(), rawMessageType))
+		if err := json.Unmarshal(data, raw.Interface()); err != nil {
+			return err
+		}
+		v.Set(reflect.MakeMapWithSize(v.Type(), raw.Elem().Len()))
+		iter := raw.Elem().MapRange()
+		for iter.Next() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := decodeDocument(iter.Value().Interface().(json.RawMessage), elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(iter.Key(), elem)
+		}
+		return nil
+	}
+
+	return json.Unmarshal(data, v.Addr().Interface())
+}
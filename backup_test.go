@@ -0,0 +1,35 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupRestoreSubcollection(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	store := NewMemoryStore()
+	require.NoError(t, store.Set(ctx, "posts", "u1", Post{UUID: "u1", ID: "acct", Text: "hello"}))
+	require.NoError(t, store.Set(ctx, "posts/u1/versions", versionKey(1), Version[Post]{Version: 1, DocKey: "u1", Doc: Post{UUID: "u1", Text: "hello"}, CreatedAt: now}))
+	require.NoError(t, store.Set(ctx, "usages", "k1", UsageRollup{AccountID: "acct", Channel: ChannelAPI, Count: 3, Start: now}))
+
+	var buf bytes.Buffer
+	counts, err := Backup(ctx, store, DefaultBackupModels(), &buf, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, counts["posts/*/versions"])
+	assert.Equal(t, 1, counts["usages"])
+
+	restored := NewMemoryStore()
+	_, counts, err = Restore(ctx, &buf, restored, DefaultBackupModels(), RestoreOptions{Collections: []string{"posts/*/versions"}})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"posts/*/versions": 1}, counts)
+
+	var v Version[Post]
+	require.NoError(t, restored.Get(ctx, "posts/u1/versions", versionKey(1), &v))
+	assert.Equal(t, "hello", v.Doc.Text)
+}
@@ -0,0 +1,480 @@
+package models
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrBillingSignature = errors.New("invalid billing signature")
+	ErrBillingEvent     = errors.New("invalid billing event")
+	// ErrBillingInProgress 同じイベントを処理中、Webhookの再配信で処理する
+	ErrBillingInProgress = errors.New("billing event is in progress")
+
+	// errBillingIgnored 適用済みより古いイベント、Subscribeを書き込まない
+	errBillingIgnored = errors.New("billing event is older than applied")
+)
+
+// DefaultBillingTolerance is max age of webhook signature when Tolerance is 0.
+const DefaultBillingTolerance = 5 * time.Minute
+
+// DefaultBillingClaimTimeout is max processing time of an event when ClaimTimeout is 0.
+// 処理中のまま停止したイベントは、経過後の再配信で処理し直す
+const DefaultBillingClaimTimeout = 5 * time.Minute
+
+// BillingEventType is a subscription lifecycle event.
+type BillingEventType string
+
+const (
+	BillingCreated BillingEventType = "created"
+	// BillingUpdated is resolved to BillingUpgraded or BillingDowngraded by BillingProcessor.
+	BillingUpdated    BillingEventType = "updated"
+	BillingUpgraded   BillingEventType = "upgraded"
+	BillingDowngraded BillingEventType = "downgraded"
+	BillingPastDue    BillingEventType = "past_due"
+	BillingCanceled   BillingEventType = "canceled"
+	// BillingPaused is a trial ended without payment method, no plan until resumed.
+	BillingPaused BillingEventType = "paused"
+	// BillingIncomplete is a first payment not completed yet, Subscribe is not changed.
+	BillingIncomplete BillingEventType = "incomplete"
+)
+
+// BillingEvent is a provider event mapped to models.
+type BillingEvent struct {
+	ID   string           `firestore:"id" json:"id"`
+	Type BillingEventType `firestore:"type" json:"type"`
+	// AccountID is Twitter/X AccountID, from subscription metadata.
+	AccountID      string         `firestore:"account_id" json:"account_id"`
+	CustomerID     string         `firestore:"customer_id,omitempty" json:"customer_id,omitempty"`
+	SubscriptionID string         `firestore:"subscription_id,omitempty" json:"subscription_id,omitempty"`
+	Plan           SubscribedPlan `firestore:"plan" json:"plan"`
+
+	PeriodStart time.Time `firestore:"period_start,omitempty" json:"period_start,omitempty"`
+	PeriodEnd   time.Time `firestore:"period_end,omitempty" json:"period_end,omitempty"`
+	TrialEnd    time.Time `firestore:"trial_end,omitempty" json:"trial_end,omitempty"`
+
+	CreatedAt time.Time `firestore:"created_at" json:"created_at"`
+}
+
+// BillingProvider verifies and parses webhook payloads.
+// StripeProvider, テスト用のFakeBillingProviderが実装する
+type BillingProvider interface {
+	ParseEvent(payload []byte, signature string, now time.Time) (BillingEvent, error)
+}
+
+// signPayload e.g. t=1700000000,v1=hex(hmac_sha256(secret, "1700000000."+payload))
+func signPayload(secret string, payload []byte, at time.Time) string {
+	ts := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return fmt.Sprintf("t=%s,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// verifySignature Stripe-Signatureヘッダ形式の署名を確認します
+// toleranceを超えて古い署名はリプレイとして拒否する、0の場合はDefaultBillingTolerance
+func verifySignature(secret string, payload []byte, header string, tolerance time.Duration, now time.Time) error {
+	if secret == "" {
+		// 空の鍵では誰でも署名を作成できる
+		return fmt.Errorf("%w: secret is not set", ErrBillingSignature)
+	}
+	if tolerance <= 0 {
+		tolerance = DefaultBillingTolerance
+	}
+
+	var ts string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			signatures = append(signatures, v)
+		}
+	}
+
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return fmt.Errorf("%w: malformed header", ErrBillingSignature)
+	}
+	at := time.Unix(sec, 0)
+	if now.Sub(at) > tolerance || at.Sub(now) > tolerance {
+		return fmt.Errorf("%w: timestamp out of tolerance", ErrBillingSignature)
+	}
+
+	_, expected, _ := strings.Cut(signPayload(secret, payload, at), "v1=")
+	for _, v := range signatures {
+		if hmac.Equal([]byte(v), []byte(expected)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: signature mismatch", ErrBillingSignature)
+}
+
+// StripeProvider parses Stripe subscription webhooks.
+type StripeProvider struct {
+	// Secret is a webhook signing secret, whsec_...
+	Secret string
+	// Tolerance is max age of signature, DefaultBillingTolerance if 0.
+	Tolerance time.Duration
+	// Prices is SubscribedPlan by price ID.
+	Prices map[string]SubscribedPlan
+}
+
+// stripeEvent is a part of Stripe event used.
+type stripeEvent struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object struct {
+			ID                 string `json:"id"`
+			Customer           string `json:"customer"`
+			Status             string `json:"status"`
+			CurrentPeriodStart int64  `json:"current_period_start"`
+			CurrentPeriodEnd   int64  `json:"current_period_end"`
+			TrialEnd           int64  `json:"trial_end"`
+			Items              struct {
+				Data []struct {
+					Price struct {
+						ID string `json:"id"`
+					} `json:"price"`
+				} `json:"data"`
+			} `json:"items"`
+			Metadata map[string]string `json:"metadata"`
+		} `json:"object"`
+	} `json:"data"`
+}
+
+// ParseEvent for interface
+// customer.subscription.*以外のイベント、プランの無い作成・更新はErrBillingEventを返す
+func (p StripeProvider) ParseEvent(payload []byte, signature string, now time.Time) (BillingEvent, error) {
+	if err := verifySignature(p.Secret, payload, signature, p.Tolerance, now); err != nil {
+		return BillingEvent{}, err
+	}
+
+	var v stripeEvent
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return BillingEvent{}, fmt.Errorf("%w: %v", ErrBillingEvent, err)
+	}
+	obj := v.Data.Object
+
+	e := BillingEvent{
+		ID:             v.ID,
+		AccountID:      obj.Metadata["account_id"],
+		CustomerID:     obj.Customer,
+		SubscriptionID: obj.ID,
+		CreatedAt:      time.Unix(v.Created, 0),
+	}
+	if obj.CurrentPeriodStart > 0 {
+		e.PeriodStart = time.Unix(obj.CurrentPeriodStart, 0)
+	}
+	if obj.CurrentPeriodEnd > 0 {
+		e.PeriodEnd = time.Unix(obj.CurrentPeriodEnd, 0)
+	}
+	if obj.TrialEnd > 0 {
+		e.TrialEnd = time.Unix(obj.TrialEnd, 0)
+	}
+	if len(obj.Items.Data) > 0 {
+		price := obj.Items.Data[0].Price.ID
+		plan, ok := p.Prices[price]
+		if !ok {
+			return e, fmt.Errorf("%w: unknown price %s", ErrBillingEvent, price)
+		}
+		e.Plan = plan
+	}
+
+	switch {
+	case !strings.HasPrefix(v.Type, "customer.subscription."):
+		return e, fmt.Errorf("%w: unsupported type %s", ErrBillingEvent, v.Type)
+	case v.Type == "customer.subscription.deleted" || obj.Status == "canceled" || obj.Status == "incomplete_expired":
+		// incomplete_expired: 初回の支払いが完了しないまま期限切れ
+		e.Type = BillingCanceled
+	case obj.Status == "incomplete":
+		// 支払いの完了前、プランを付与しない
+		e.Type = BillingIncomplete
+	case obj.Status == "paused":
+		e.Type = BillingPaused
+	case obj.Status == "past_due" || obj.Status == "unpaid":
+		e.Type = BillingPastDue
+	case v.Type == "customer.subscription.created":
+		e.Type = BillingCreated
+	case v.Type == "customer.subscription.updated":
+		e.Type = BillingUpdated
+	default:
+		return e, fmt.Errorf("%w: unsupported type %s", ErrBillingEvent, v.Type)
+	}
+
+	if (e.Type == BillingCreated || e.Type == BillingUpdated) && len(obj.Items.Data) == 0 {
+		// プランが不明のまま、Unsubscribedとして反映しない
+		return e, fmt.Errorf("%w: missing items", ErrBillingEvent)
+	}
+
+	if e.ID == "" || e.AccountID == "" {
+		return e, fmt.Errorf("%w: missing id or account_id", ErrBillingEvent)
+	}
+	return e, nil
+}
+
+// FakeBillingProvider is a local provider for tests, payload is BillingEvent as JSON.
+type FakeBillingProvider struct {
+	Secret string
+}
+
+// NewFakeBillingProvider is constructor
+func NewFakeBillingProvider(secret string) *FakeBillingProvider {
+	return &FakeBillingProvider{Secret: secret}
+}
+
+// Emit 署名付きのWebhookを生成します
+func (p *FakeBillingProvider) Emit(e BillingEvent, now time.Time) ([]byte, string, error) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return nil, "", err
+	}
+	return payload, signPayload(p.Secret, payload, now), nil
+}
+
+// ParseEvent for interface
+func (p *FakeBillingProvider) ParseEvent(payload []byte, signature string, now time.Time) (BillingEvent, error) {
+	if err := verifySignature(p.Secret, payload, signature, 0, now); err != nil {
+		return BillingEvent{}, err
+	}
+	var e BillingEvent
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return e, fmt.Errorf("%w: %v", ErrBillingEvent, err)
+	}
+	return e, nil
+}
+
+// BillingRecord is a processed event, keyed by event ID for idempotency.
+type BillingRecord struct {
+	ID        string           `firestore:"id" json:"id"`
+	Type      BillingEventType `firestore:"type" json:"type"`
+	AccountID string           `firestore:"account_id" json:"account_id"`
+	From      SubscribedPlan   `firestore:"from" json:"from"`
+	To        SubscribedPlan   `firestore:"to" json:"to"`
+	// Ignored is true for an event older than the applied one, or not changing Subscribe.
+	Ignored bool `firestore:"ignored,omitempty" json:"ignored,omitempty"`
+	// Completed is false while processing, the event is processed again after ClaimTimeout.
+	Completed bool `firestore:"completed" json:"completed"`
+
+	ClaimedAt   time.Time `firestore:"claimed_at" json:"claimed_at"`
+	ProcessedAt time.Time `firestore:"processed_at,omitempty" json:"processed_at,omitempty"`
+}
+
+// BillingResult is a result of BillingProcessor.Handle.
+type BillingResult struct {
+	Record BillingRecord
+	// Duplicate is true when the event was already processed.
+	Duplicate bool
+}
+
+// BillingProcessor applies billing events to Subscribe.
+type BillingProcessor struct {
+	Store               Store
+	SubscribeCollection string
+	EventCollection     string
+	// Catalogs is optional, DefaultPlanCatalogs if nil.
+	Catalogs *PlanCatalogs
+	// Audit is optional, records AuditPlanChange.
+	Audit *AuditLog
+	Clock Clock
+	// ClaimTimeout is max processing time of an event, DefaultBillingClaimTimeout if 0.
+	ClaimTimeout time.Duration
+}
+
+func (p BillingProcessor) now() time.Time {
+	if p.Clock == nil {
+		return SystemClock.Now()
+	}
+	return p.Clock.Now()
+}
+
+// Handle Webhookを検証し、購読プランに反映します
+// 処理済みのイベントはDuplicateを返し、何もしない。適用済みのイベントより古いイベントは記録のみ行う
+// 処理中のイベントはErrBillingInProgressを返す、ClaimTimeoutを過ぎた処理中のイベントは処理し直す
+func (p BillingProcessor) Handle(ctx context.Context, provider BillingProvider, payload []byte, signature string) (*BillingResult, error) {
+	now := p.now()
+	e, err := provider.ParseEvent(payload, signature, now)
+	if err != nil {
+		return nil, err
+	}
+
+	record := BillingRecord{
+		ID:        e.ID,
+		Type:      e.Type,
+		AccountID: e.AccountID,
+		ClaimedAt: now,
+	}
+
+	// 先にイベントIDを登録し、同じイベントの同時配信を1件のみ処理する
+	timeout := p.ClaimTimeout
+	if timeout <= 0 {
+		timeout = DefaultBillingClaimTimeout
+	}
+	var claim BillingRecord
+	err = UpdateDocument(ctx, p.Store, p.EventCollection, e.ID, &claim, func(exists bool) error {
+		switch {
+		case exists && claim.Completed:
+			return ErrAlreadyExists
+		case exists && now.Sub(claim.ClaimedAt) < timeout:
+			return fmt.Errorf("%w: %s", ErrBillingInProgress, e.ID)
+		}
+		// 未登録、または処理中のまま停止したイベント
+		claim = record
+		return nil
+	})
+	switch {
+	case errors.Is(err, ErrAlreadyExists):
+		return &BillingResult{Record: claim, Duplicate: true}, nil
+	case err != nil:
+		return nil, err
+	}
+
+	record, err = p.process(ctx, e, record, now)
+	if err != nil {
+		// 再配信で処理できるよう、登録を取り消す
+		if derr := p.Store.Delete(ctx, p.EventCollection, e.ID); derr != nil {
+			return nil, fmt.Errorf("%v, error releasing event: %v", err, derr)
+		}
+		return nil, err
+	}
+
+	record.Completed = true
+	record.ProcessedAt = now
+	if err := p.Store.Set(ctx, p.EventCollection, e.ID, record); err != nil {
+		return nil, err
+	}
+	return &BillingResult{Record: record}, nil
+}
+
+// process イベントをSubscribeに反映し、記録を返します
+func (p BillingProcessor) process(ctx context.Context, e BillingEvent, record BillingRecord, now time.Time) (BillingRecord, error) {
+	if e.Type == BillingIncomplete {
+		record.Ignored = true
+		return record, nil
+	}
+
+	var before Subscribe
+	s := NewSubscribe()
+	err := UpdateDocument(ctx, p.Store, p.SubscribeCollection, e.AccountID, s, func(exists bool) error {
+		if !exists {
+			*s = *NewSubscribe()
+			s.ID = e.AccountID
+		}
+		before = *s
+		record.From, record.To = s.Plan, s.Plan
+
+		if !s.BillingUpdatedAt.IsZero() && e.CreatedAt.Before(s.BillingUpdatedAt) {
+			record.Ignored = true
+			return errBillingIgnored
+		}
+		record.Type = p.apply(s, e, now)
+		record.To = s.Plan
+		return nil
+	})
+	switch {
+	case errors.Is(err, errBillingIgnored):
+		return record, nil
+	case err != nil:
+		return record, err
+	}
+
+	if p.Audit != nil && record.From != record.To {
+		event := NewAuditEvent(AuditPlanChange, "billing:"+e.ID, e.AccountID, p.SubscribeCollection+"/"+e.AccountID, before, *s)
+		if _, err := p.Audit.Record(ctx, event); err != nil {
+			return record, err
+		}
+	}
+	return record, nil
+}
+
+// apply イベントをSubscribeに反映し、確定したイベントの種類を返します
+func (p BillingProcessor) apply(s *Subscribe, e BillingEvent, now time.Time) BillingEventType {
+	s.CustomerID = e.CustomerID
+	s.SubscriptionID = e.SubscriptionID
+	s.BillingUpdatedAt = e.CreatedAt
+	s.TrialEnd = e.TrialEnd
+
+	switch e.Type {
+	case BillingCanceled:
+		s.BillingStatus = string(BillingCanceled)
+		s.Plan = Unsubscribed
+		s.applyLimits(PlanDefinition{})
+		return BillingCanceled
+	case BillingPaused:
+		// 再開されるまでプランを付与しない
+		s.BillingStatus = string(BillingPaused)
+		s.Plan = Unsubscribed
+		s.applyLimits(PlanDefinition{})
+		return BillingPaused
+	case BillingPastDue:
+		// 猶予期間中はプランを維持し、期限後にDowngradeToへ変更する
+		s.MarkPastDue(now)
+		return BillingPastDue
+	}
+
+	typ := e.Type
+	if typ == BillingUpdated {
+		switch {
+		case e.Plan > s.Plan:
+			typ = BillingUpgraded
+		case e.Plan < s.Plan:
+			typ = BillingDowngraded
+		}
+	}
+	if !e.PeriodEnd.IsZero() {
+		s.Renew(e.PeriodStart, e.PeriodEnd)
+	} else {
+		s.GraceEnd = time.Time{}
+		s.BillingStatus = SubscribeActive
+	}
+	if !s.TrialEnd.IsZero() && now.Before(s.TrialEnd) {
+		s.BillingStatus = SubscribeTrialing
+	}
+
+	old := make(map[string]Managed)
+	for _, name := range []string{ChannelGUI, ChannelAPI} {
+		old[name] = s.Channel(name)
+	}
+	for name, m := range s.Channels {
+		old[name] = m
+	}
+	from := s.Plan
+	catalogs := p.Catalogs
+	if catalogs == nil {
+		catalogs = DefaultPlanCatalogs()
+	}
+	s.SetWithCatalog(catalogs, e.Plan, now)
+	if from != Unsubscribed && from != e.Plan {
+		s.prorate(old, s.PeriodStart, s.PeriodEnd, now)
+	}
+	return typ
+}
+
+// prorate 期間途中のプラン変更では、月の使用制限を残り期間で按分します
+// 月の使用制限 = 変更前 + (変更後 - 変更前) * 残り期間の割合、日・時間の使用制限は即時に変更する
+func (s *Subscribe) prorate(before map[string]Managed, start, end time.Time, now time.Time) {
+	if start.IsZero() || !end.After(start) || !now.After(start) || !now.Before(end) {
+		return
+	}
+	remaining := float64(end.Sub(now)) / float64(end.Sub(start))
+
+	for name, m := range s.Channels {
+		old := before[name].Limit.Monthly
+		next := m.Limit.Monthly
+		m.Limit.Monthly = clampCount(float64(old) + (float64(next)-float64(old))*remaining)
+		s.setChannel(name, m)
+	}
+}
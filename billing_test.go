@@ -0,0 +1,88 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stripePayload(typ, status string, items bool) []byte {
+	data := `{"data":[]}`
+	if items {
+		data = `{"data":[{"price":{"id":"price_basic"}}]}`
+	}
+	return []byte(fmt.Sprintf(`{"id":"evt_1","type":%q,"created":1700000000,"data":{"object":{"id":"sub_1","customer":"cus_1","status":%q,"items":%s,"metadata":{"account_id":"acct"}}}}`,
+		typ, status, data))
+}
+
+func TestStripeProviderParseEvent(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	provider := StripeProvider{Secret: "whsec_test", Prices: map[string]SubscribedPlan{"price_basic": SubscribedBasic}}
+
+	tests := []struct {
+		name    string
+		typ     string
+		status  string
+		items   bool
+		want    BillingEventType
+		wantErr bool
+	}{
+		{name: "created", typ: "customer.subscription.created", status: "active", items: true, want: BillingCreated},
+		{name: "incomplete", typ: "customer.subscription.created", status: "incomplete", items: true, want: BillingIncomplete},
+		{name: "paused", typ: "customer.subscription.updated", status: "paused", items: true, want: BillingPaused},
+		{name: "canceled without items", typ: "customer.subscription.deleted", status: "canceled", want: BillingCanceled},
+		{name: "missing items", typ: "customer.subscription.updated", status: "active", wantErr: true},
+		{name: "other event", typ: "invoice.paid", status: "canceled", items: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := stripePayload(tt.typ, tt.status, tt.items)
+			e, err := provider.ParseEvent(payload, signPayload(provider.Secret, payload, now), now)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrBillingEvent)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, e.Type)
+		})
+	}
+}
+
+func TestBillingProcessorReclaimsStalledEvent(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	clock := NewFixedClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	processor := BillingProcessor{
+		Store:               store,
+		SubscribeCollection: "subscribes",
+		EventCollection:     "billing_events",
+		Clock:               clock,
+	}
+	provider := NewFakeBillingProvider("secret")
+	event := BillingEvent{ID: "evt_1", Type: BillingCreated, AccountID: "acct", Plan: SubscribedBasic, CreatedAt: clock.Now()}
+
+	// 登録後、処理を完了する前に停止した
+	require.NoError(t, store.Set(ctx, "billing_events", event.ID, BillingRecord{ID: event.ID, ClaimedAt: clock.Now()}))
+
+	payload, signature, err := provider.Emit(event, clock.Now())
+	require.NoError(t, err)
+	_, err = processor.Handle(ctx, provider, payload, signature)
+	assert.ErrorIs(t, err, ErrBillingInProgress)
+
+	clock.Add(DefaultBillingClaimTimeout)
+	payload, signature, err = provider.Emit(event, clock.Now())
+	require.NoError(t, err)
+	result, err := processor.Handle(ctx, provider, payload, signature)
+	require.NoError(t, err)
+	assert.False(t, result.Duplicate)
+	assert.True(t, result.Record.Completed)
+	assert.Equal(t, SubscribedBasic, result.Record.To)
+
+	result, err = processor.Handle(ctx, provider, payload, signature)
+	require.NoError(t, err)
+	assert.True(t, result.Duplicate)
+}
@@ -0,0 +1,377 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// ExportKind is a value type of ExportColumn.
+type ExportKind uint8
+
+const (
+	ExportString ExportKind = iota
+	ExportInt
+	ExportFloat
+	ExportBool
+	ExportTime
+	// ExportJSON is slice, map or other values, encoded as JSON string in parquet.
+	ExportJSON
+)
+
+// ExportColumn is a flattened column for analytics export.
+// 名前はfirestoreタグ名、入れ子の構造体は"_"で連結する
+type ExportColumn struct {
+	Name string
+	Kind ExportKind
+	// Mask is true for `export:"mask"` fields, masked by Mask.
+	Mask bool
+
+	index []int
+}
+
+// ExportSchema is columns derived from struct tags.
+// `export:"-"`のフィールドは出力しない
+type ExportSchema struct {
+	Name    string
+	Columns []ExportColumn
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// ExportSchemaOf 構造体のタグから出力スキーマを生成します
+func ExportSchemaOf(v any) ExportSchema {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	schema := ExportSchema{Name: strings.ToLower(t.Name())}
+	schema.Columns = exportColumns(t, "", nil)
+	return schema
+}
+
+func exportColumns(t reflect.Type, prefix string, index []int) []ExportColumn {
+	columns := []ExportColumn{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		mode := f.Tag.Get("export")
+		if mode == "-" {
+			continue
+		}
+
+		name := exportName(f)
+		if name == "" {
+			continue
+		}
+		name = prefix + name
+		idx := append(append([]int{}, index...), i)
+
+		ft := f.Type
+		if ft.Kind() == reflect.Struct && ft != timeType {
+			columns = append(columns, exportColumns(ft, name+"_", idx)...)
+			continue
+		}
+
+		columns = append(columns, ExportColumn{
+			Name:  name,
+			Kind:  exportKind(ft),
+			Mask:  mode == "mask",
+			index: idx,
+		})
+	}
+	return columns
+}
+
+// exportName firestoreタグ名、無い場合はjsonタグ名、フィールド名の順に使用する
+func exportName(f reflect.StructField) string {
+	for _, key := range []string{"firestore", "json"} {
+		name, _, _ := strings.Cut(f.Tag.Get(key), ",")
+		if name == "-" {
+			continue
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return strings.ToLower(f.Name)
+}
+
+func exportKind(t reflect.Type) ExportKind {
+	if t == timeType {
+		return ExportTime
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return ExportString
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return ExportInt
+	case reflect.Float32, reflect.Float64:
+		return ExportFloat
+	case reflect.Bool:
+		return ExportBool
+	}
+	return ExportJSON
+}
+
+// Names カラム名を返します
+func (p ExportSchema) Names() []string {
+	names := make([]string, len(p.Columns))
+	for i, v := range p.Columns {
+		names[i] = v.Name
+	}
+	return names
+}
+
+// values 1件分の値をカラム順に返します、ゼロ値の時刻はnil
+func (p ExportSchema) values(v any) []any {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		rv = rv.Elem()
+	}
+
+	values := make([]any, len(p.Columns))
+	for i, c := range p.Columns {
+		f := rv.FieldByIndex(c.index)
+		switch c.Kind {
+		case ExportString:
+			s := f.String()
+			if c.Mask {
+				s = Mask(s)
+			}
+			values[i] = s
+		case ExportInt:
+			if f.CanInt() {
+				values[i] = f.Int()
+			} else {
+				values[i] = int64(f.Uint())
+			}
+		case ExportFloat:
+			values[i] = f.Float()
+		case ExportBool:
+			values[i] = f.Bool()
+		case ExportTime:
+			t := f.Interface().(time.Time)
+			if t.IsZero() {
+				values[i] = nil
+			} else {
+				values[i] = t.UTC()
+			}
+		case ExportJSON:
+			values[i] = sanitizeExport(f)
+		}
+	}
+	return values
+}
+
+// sanitizeExport 入れ子の値からも`export:"-"`のフィールドを除外します
+func sanitizeExport(v reflect.Value) any {
+	return documentValue(v, true)
+}
+
+// documentValue 構造体をfirestoreタグ名をキーとするmapに変換します
+// exportがtrueの場合は`export:"-"`のフィールドを除外し、`export:"mask"`のフィールドをマスクする
+func documentValue(v reflect.Value, export bool) any {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return documentValue(v.Elem(), export)
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return v.Interface()
+		}
+		m := make(map[string]any)
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() || (export && f.Tag.Get("export") == "-") {
+				continue
+			}
+			if name := exportName(f); name != "" {
+				value := documentValue(v.Field(i), export)
+				if s, ok := value.(string); ok && export && f.Tag.Get("export") == "mask" {
+					value = Mask(s)
+				}
+				m[name] = value
+			}
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		list := make([]any, v.Len())
+		for i := range list {
+			list[i] = documentValue(v.Index(i), export)
+		}
+		return list
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		m := make(map[string]any, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			m[fmt.Sprint(iter.Key().Interface())] = documentValue(iter.Value(), export)
+		}
+		return m
+	}
+	return v.Interface()
+}
+
+// RecordWriter writes records one by one.
+type RecordWriter interface {
+	Write(v any) error
+	Close() error
+}
+
+// JSONLWriter writes records as JSON Lines, keys in schema order.
+type JSONLWriter struct {
+	w      io.Writer
+	schema ExportSchema
+}
+
+// NewJSONLWriter is constructor
+func NewJSONLWriter(w io.Writer, schema ExportSchema) *JSONLWriter {
+	return &JSONLWriter{w: w, schema: schema}
+}
+
+// Write 1件を1行のJSONとして書き出します
+func (p *JSONLWriter) Write(v any) error {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, value := range p.schema.values(v) {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(p.schema.Columns[i].Name)
+		b.Write(key)
+		b.WriteByte(':')
+
+		data, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("error encoding %s: %v", p.schema.Columns[i].Name, err)
+		}
+		b.Write(data)
+	}
+	b.WriteString("}\n")
+
+	_, err := p.w.Write(b.Bytes())
+	return err
+}
+
+// Close for interface
+func (p *JSONLWriter) Close() error {
+	return nil
+}
+
+// ParquetWriter writes records as Apache Parquet.
+// 全てのカラムはOPTIONAL、ExportJSONはJSON文字列として出力する
+type ParquetWriter struct {
+	schema  ExportSchema
+	writer  *parquet.Writer
+	columns []int
+}
+
+// NewParquetWriter is constructor
+func NewParquetWriter(w io.Writer, schema ExportSchema) *ParquetWriter {
+	group := parquet.Group{}
+	for _, c := range schema.Columns {
+		var node parquet.Node
+		switch c.Kind {
+		case ExportInt:
+			node = parquet.Int(64)
+		case ExportFloat:
+			node = parquet.Leaf(parquet.DoubleType)
+		case ExportBool:
+			node = parquet.Leaf(parquet.BooleanType)
+		case ExportTime:
+			node = parquet.Timestamp(parquet.Millisecond)
+		default:
+			node = parquet.String()
+		}
+		group[c.Name] = parquet.Optional(node)
+	}
+
+	ps := parquet.NewSchema(schema.Name, group)
+
+	// Groupはカラム名順に並ぶため、カラムごとの位置を保持する
+	columns := make([]int, len(schema.Columns))
+	for i, c := range schema.Columns {
+		leaf, _ := ps.Lookup(c.Name)
+		columns[i] = leaf.ColumnIndex
+	}
+
+	return &ParquetWriter{
+		schema:  schema,
+		writer:  parquet.NewWriter(w, ps),
+		columns: columns,
+	}
+}
+
+// Write 1件を書き出します
+func (p *ParquetWriter) Write(v any) error {
+	values := p.schema.values(v)
+	row := make(parquet.Row, len(values))
+	for i, value := range values {
+		var pv parquet.Value
+		switch value := value.(type) {
+		case nil:
+			row[p.columns[i]] = parquet.Value{}.Level(0, 0, p.columns[i])
+			continue
+		case string:
+			pv = parquet.ByteArrayValue([]byte(value))
+		case int64:
+			pv = parquet.Int64Value(value)
+		case float64:
+			pv = parquet.DoubleValue(value)
+		case bool:
+			pv = parquet.BooleanValue(value)
+		case time.Time:
+			pv = parquet.Int64Value(value.UnixMilli())
+		default:
+			data, err := json.Marshal(value)
+			if err != nil {
+				return fmt.Errorf("error encoding %s: %v", p.schema.Columns[i].Name, err)
+			}
+			pv = parquet.ByteArrayValue(data)
+		}
+		row[p.columns[i]] = pv.Level(0, 1, p.columns[i])
+	}
+
+	_, err := p.writer.WriteRows([]parquet.Row{row})
+	return err
+}
+
+// Close フッターを書き出します、必ず呼び出すこと
+func (p *ParquetWriter) Close() error {
+	return p.writer.Close()
+}
+
+// Export コレクションを1件ずつ読み込み、RecordWriterに書き出します
+// 全件をメモリに読み込まないため、StoreはIteratorを実装していることが望ましい
+func Export[T any](ctx context.Context, store Store, colName string, filters []Filter, w RecordWriter) (int, error) {
+	n := 0
+	err := Each(ctx, store, colName, filters, func(v T) error {
+		if err := w.Write(v); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
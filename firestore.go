@@ -8,7 +8,10 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"cloud.google.com/go/firestore"
 )
@@ -44,6 +47,9 @@ func (p *ClientForFirestore) Get(ctx context.Context, colName, docKey string, da
 
 	doc, err := client.Collection(colName).Doc(docKey).Get(ctx)
 	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return fmt.Errorf("%w: %s/%s", ErrNotFound, colName, docKey)
+		}
 		return fmt.Errorf("error setting document: %v", err)
 	}
 
@@ -78,12 +84,24 @@ func (p *ClientForFirestore) Set(ctx context.Context, colName, docKey string, da
 			}
 		}
 
+	case []User:
+		// 顧客ユーザーの登録
+		// id, keyはUUIDで生成済み、連携アカウントはドキュメント内に保持
+		for _, v := range value {
+			if _, err := client.Collection(colName).Doc(v.UUID).Set(ctx, v); err != nil {
+				log.Error().Err(err).Msgf("error setting document: data type %s", reflect.TypeOf(v).String())
+				continue
+			}
+		}
+
 	case []Post:
 		// 顧客投稿データの登録
-		// id, keyはuuidで生成
-		// 現状、投稿分の重複は容認、考慮せず
+		// id, keyはuuidで生成、取り込み済みのUUIDを持つ場合は上書き
+		// 行との対応付けはSheetImporterを使用する
 		for _, v := range value {
-			v.UUID = uuid.New().String()
+			if v.UUID == "" {
+				v.UUID = uuid.New().String()
+			}
 			v.SetCreateAt()
 
 			if _, err := client.Collection(colName).Doc(v.UUID).Set(ctx, v); err != nil {
@@ -120,3 +138,123 @@ func (p *ClientForFirestore) IsExist(ctx context.Context, colName string, docKey
 
 	return isExistKeys, nil
 }
+
+// Update for Updater, トランザクションで読み込みと書き込みを行う
+// 競合した場合、fnは再実行されることがある
+func (p *ClientForFirestore) Update(ctx context.Context, colName, docKey string, data any, fn func(exists bool) error) error {
+	client, err := p.NewClient(ctx)
+	if err != nil {
+		return fmt.Errorf("error initializing firestore: %v", err)
+	}
+	defer client.Close()
+
+	ref := client.Collection(colName).Doc(docKey)
+	return client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		// 再実行時に前回のfnの変更を引き継がない
+		reflect.ValueOf(data).Elem().Set(reflect.Zero(reflect.TypeOf(data).Elem()))
+
+		exists := true
+		doc, err := tx.Get(ref)
+		switch {
+		case status.Code(err) == codes.NotFound:
+			exists = false
+		case err != nil:
+			return fmt.Errorf("error getting document: %v", err)
+		default:
+			if err := doc.DataTo(data); err != nil {
+				return fmt.Errorf("error getting data: %v", err)
+			}
+		}
+
+		if err := fn(exists); err != nil {
+			return err
+		}
+		return tx.Set(ref, reflect.ValueOf(data).Elem().Interface())
+	})
+}
+
+// Delete ドキュメントを物理削除します
+func (p *ClientForFirestore) Delete(ctx context.Context, colName, docKey string) error {
+	client, err := p.NewClient(ctx)
+	if err != nil {
+		return fmt.Errorf("error initializing firestore: %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.Collection(colName).Doc(docKey).Delete(ctx); err != nil {
+		return fmt.Errorf("error deleting document: %v", err)
+	}
+
+	return nil
+}
+
+// List dataはsliceのpointer, 参照渡し
+// filtersはfirestoreタグ名による等価条件
+func (p *ClientForFirestore) List(ctx context.Context, colName string, filters []Filter, data any) error {
+	dst := reflect.ValueOf(data)
+	if dst.Kind() != reflect.Pointer || dst.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("error listing documents: data must be pointer to slice, data type: %s", dst.Type())
+	}
+
+	client, err := p.NewClient(ctx)
+	if err != nil {
+		return fmt.Errorf("error initializing firestore: %v", err)
+	}
+	defer client.Close()
+
+	q := client.Collection(colName).Query
+	for _, f := range filters {
+		q = q.Where(f.Field, "==", f.Value)
+	}
+
+	docs, err := q.Documents(ctx).GetAll()
+	if err != nil {
+		return fmt.Errorf("error listing documents: %v", err)
+	}
+
+	slice := dst.Elem()
+	elemType := slice.Type().Elem()
+	for _, doc := range docs {
+		v := reflect.New(elemType)
+		if err := doc.DataTo(v.Interface()); err != nil {
+			log.Error().Err(err).Msgf("error getting data: %s, data type %s", doc.Ref.ID, elemType.String())
+			continue
+		}
+		slice = reflect.Append(slice, v.Elem())
+	}
+	dst.Elem().Set(slice)
+
+	return nil
+}
+
+// Iterate ドキュメントを1件ずつ処理します
+func (p *ClientForFirestore) Iterate(ctx context.Context, colName string, filters []Filter, fn func(key string, decode func(data any) error) error) error {
+	client, err := p.NewClient(ctx)
+	if err != nil {
+		return fmt.Errorf("error initializing firestore: %v", err)
+	}
+	defer client.Close()
+
+	q := client.Collection(colName).Query
+	for _, f := range filters {
+		q = q.Where(f.Field, "==", f.Value)
+	}
+
+	iter := q.Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error iterating documents: %v", err)
+		}
+
+		if err := fn(doc.Ref.ID, doc.DataTo); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -32,3 +32,9 @@ func CheckDuplicate(str []string) []string {
 	}
 	return list
 }
+
+// Owner は所有者IDを持つリソースを表します
+// 権限確認(Workspace.Authorize)に使用されます
+type Owner interface {
+	GetOwnerID() string
+}
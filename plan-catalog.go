@@ -0,0 +1,277 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var ErrPlanCatalog = errors.New("invalid plan catalog")
+
+const (
+	// ChannelGUI is a quota channel of posting from GUI.
+	ChannelGUI = "gui"
+	// ChannelAPI is a quota channel of posting from API.
+	ChannelAPI = "api"
+	// ChannelMedia is a quota channel of media uploads.
+	ChannelMedia = "media"
+	// ChannelAIText is a quota channel of AI text generation.
+	ChannelAIText = "ai_text"
+	// ChannelThread is a quota channel of thread posting.
+	ChannelThread = "thread"
+)
+
+func (p SubscribedPlan) String() string {
+	switch p {
+	case SubscribedFree:
+		return "free"
+	case SubscribedBasic:
+		return "basic"
+	case SubscribedPro:
+		return "pro"
+	}
+	return "unsubscribed"
+}
+
+// ParseSubscribedPlan プラン名からSubscribedPlanを返します
+func ParseSubscribedPlan(s string) (SubscribedPlan, error) {
+	for _, v := range []SubscribedPlan{Unsubscribed, SubscribedFree, SubscribedBasic, SubscribedPro} {
+		if strings.EqualFold(v.String(), strings.TrimSpace(s)) {
+			return v, nil
+		}
+	}
+	return Unsubscribed, fmt.Errorf("unknown plan: %s", s)
+}
+
+// PlanDefinition is limits and features of a plan.
+type PlanDefinition struct {
+	// Limits is usage limits by channel, e.g. ChannelGUI, ChannelAPI.
+	Limits map[string]Count `firestore:"limits,omitempty" json:"limits,omitempty" yaml:"limits,omitempty"`
+	// Features is feature flags, missing feature is disabled.
+	Features map[string]bool `firestore:"features,omitempty" json:"features,omitempty" yaml:"features,omitempty"`
+	// Versions is retention of document versions, DefaultVersionRetention if nil.
+	Versions *VersionRetention `firestore:"versions,omitempty" json:"versions,omitempty" yaml:"versions,omitempty"`
+}
+
+// Has 機能が有効かどうかを返します
+func (p PlanDefinition) Has(feature string) bool {
+	return p.Features[feature]
+}
+
+// PlanCatalog is a version of plan definitions.
+// 価格改定はリリースではなく新しいバージョンのカタログを追加して行う
+type PlanCatalog struct {
+	Version int `firestore:"version" json:"version" yaml:"version"`
+	// Plans is definitions by plan name, e.g. free, basic, pro.
+	Plans map[string]PlanDefinition `firestore:"plans" json:"plans" yaml:"plans"`
+
+	// EffectiveAt is when the catalog applies to new subscriptions, zero is always.
+	EffectiveAt time.Time `firestore:"effective_at,omitempty" json:"effective_at,omitempty" yaml:"effective_at,omitempty"`
+}
+
+// Plan 購読プランの定義を返します
+func (p PlanCatalog) Plan(plan SubscribedPlan) (PlanDefinition, bool) {
+	v, ok := p.Plans[plan.String()]
+	return v, ok
+}
+
+// VersionRetention 購読プランのバージョンの保持期間を返します
+// カタログに無いプラン、Versionsの無いプランはDefaultVersionRetention
+func (p PlanCatalog) VersionRetention(plan SubscribedPlan) VersionRetention {
+	v, ok := p.Plan(plan)
+	if !ok || v.Versions == nil {
+		return DefaultVersionRetention
+	}
+	return *v.Versions
+}
+
+// Validate バージョン、プラン名を確認します
+func (p PlanCatalog) Validate() error {
+	if p.Version <= 0 {
+		return fmt.Errorf("%w: version must be positive, version: %d", ErrPlanCatalog, p.Version)
+	}
+	for name := range p.Plans {
+		if _, err := ParseSubscribedPlan(name); err != nil {
+			return fmt.Errorf("%w: version %d: %v", ErrPlanCatalog, p.Version, err)
+		}
+	}
+	return nil
+}
+
+// LegacyPlanCatalog is version 1, the limits hard-coded in Subscribe.Set before the catalog.
+// 既存の購読者の制限を変えないため、値は変更しないこと
+// Basic, ProのAPI制限が同一である点は新しいバージョンで見直す
+func LegacyPlanCatalog() PlanCatalog {
+	return PlanCatalog{
+		Version: 1,
+		Plans: map[string]PlanDefinition{
+			SubscribedFree.String(): {
+				Limits: map[string]Count{
+					ChannelGUI: {Monthly: 0, Daily: 0, Hourly: 0},
+					ChannelAPI: {Monthly: 50, Daily: 3, Hourly: 1},
+				},
+				Versions: &VersionRetention{MaxVersions: 5, MaxAge: 7 * 24 * time.Hour},
+			},
+			SubscribedBasic.String(): {
+				Limits: map[string]Count{
+					ChannelGUI: {Monthly: 30, Daily: 1, Hourly: 1},
+					ChannelAPI: {Monthly: 10000, Daily: 1000, Hourly: 100},
+				},
+				Versions: &VersionRetention{MaxVersions: 30, MaxAge: 90 * 24 * time.Hour},
+			},
+			SubscribedPro.String(): {
+				Limits: map[string]Count{
+					ChannelGUI: {Monthly: 1000, Daily: 100, Hourly: 10},
+					ChannelAPI: {Monthly: 10000, Daily: 1000, Hourly: 100},
+				},
+				Versions: &VersionRetention{MaxVersions: 100, MaxAge: 365 * 24 * time.Hour},
+			},
+		},
+	}
+}
+
+// PlanCatalogs is all versions of PlanCatalog.
+type PlanCatalogs struct {
+	catalogs []PlanCatalog
+}
+
+// NewPlanCatalogs is constructor
+// 同じバージョンのカタログは後のものを使用する
+func NewPlanCatalogs(catalogs ...PlanCatalog) (*PlanCatalogs, error) {
+	byVersion := make(map[int]PlanCatalog, len(catalogs))
+	for _, v := range catalogs {
+		if err := v.Validate(); err != nil {
+			return nil, err
+		}
+		byVersion[v.Version] = v
+	}
+	if len(byVersion) == 0 {
+		return nil, fmt.Errorf("%w: no catalog", ErrPlanCatalog)
+	}
+
+	p := &PlanCatalogs{}
+	for _, v := range byVersion {
+		p.catalogs = append(p.catalogs, v)
+	}
+	sort.Slice(p.catalogs, func(i, j int) bool {
+		return p.catalogs[i].Version < p.catalogs[j].Version
+	})
+	return p, nil
+}
+
+// Version 指定したバージョンのカタログを返します
+func (p *PlanCatalogs) Version(version int) (PlanCatalog, bool) {
+	for _, v := range p.catalogs {
+		if v.Version == version {
+			return v, true
+		}
+	}
+	return PlanCatalog{}, false
+}
+
+// Current 指定日時に有効な最新のカタログを返します
+// 有効なカタログが無い場合は最も古いカタログ
+func (p *PlanCatalogs) Current(at time.Time) PlanCatalog {
+	current := p.catalogs[0]
+	for _, v := range p.catalogs {
+		if v.EffectiveAt.IsZero() || !v.EffectiveAt.After(at) {
+			current = v
+		}
+	}
+	return current
+}
+
+// Resolve 購読者に適用するカタログを返します
+// 同じプランを継続している購読者は契約時のバージョンに据え置く(grandfathering)
+// バージョン0はカタログ導入前の購読者で、最も古いカタログを適用する
+func (p *PlanCatalogs) Resolve(s Subscribe, plan SubscribedPlan, at time.Time) PlanCatalog {
+	if s.Plan == plan && plan != Unsubscribed {
+		if s.CatalogVersion == 0 {
+			return p.catalogs[0]
+		}
+		if v, ok := p.Version(s.CatalogVersion); ok {
+			return v
+		}
+	}
+	return p.Current(at)
+}
+
+var (
+	planCatalogsMu sync.RWMutex
+	planCatalogs   = &PlanCatalogs{catalogs: []PlanCatalog{LegacyPlanCatalog()}}
+)
+
+// DefaultPlanCatalogs Subscribe.Setが使用するカタログを返します
+func DefaultPlanCatalogs() *PlanCatalogs {
+	planCatalogsMu.RLock()
+	defer planCatalogsMu.RUnlock()
+	return planCatalogs
+}
+
+// SetDefaultPlanCatalogs Subscribe.Setが使用するカタログを設定します
+// 起動時にLoadPlanCatalogFile, LoadPlanCatalogsで読み込んだカタログを設定する
+func SetDefaultPlanCatalogs(catalogs *PlanCatalogs) {
+	planCatalogsMu.Lock()
+	defer planCatalogsMu.Unlock()
+	planCatalogs = catalogs
+}
+
+// DecodePlanCatalog YAMLまたはJSONのカタログを読み込みます
+// JSONはYAMLとして読み込めるため、形式の指定は不要
+func DecodePlanCatalog(r io.Reader) (PlanCatalog, error) {
+	var catalog PlanCatalog
+	if err := yaml.NewDecoder(r).Decode(&catalog); err != nil {
+		return catalog, fmt.Errorf("%w: %v", ErrPlanCatalog, err)
+	}
+	return catalog, catalog.Validate()
+}
+
+// LoadPlanCatalogFile ファイルからカタログを読み込みます、1ファイル1バージョン
+// LoadPlanCatalogsと同様に、最も古いカタログとしてLegacyPlanCatalogを含める
+func LoadPlanCatalogFile(paths ...string) (*PlanCatalogs, error) {
+	catalogs := []PlanCatalog{LegacyPlanCatalog()}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("error opening plan catalog: %v", err)
+		}
+		catalog, err := DecodePlanCatalog(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		catalogs = append(catalogs, catalog)
+	}
+	return NewPlanCatalogs(catalogs...)
+}
+
+// LoadPlanCatalogs コレクションから全てのバージョンのカタログを読み込みます
+// 最も古いカタログとしてLegacyPlanCatalogを含める、同じバージョンが保存されている場合はそちらを優先する
+func LoadPlanCatalogs(ctx context.Context, store Store, colName string) (*PlanCatalogs, error) {
+	catalogs := []PlanCatalog{LegacyPlanCatalog()}
+	err := Each(ctx, store, colName, nil, func(v PlanCatalog) error {
+		catalogs = append(catalogs, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewPlanCatalogs(catalogs...)
+}
+
+// SavePlanCatalog カタログを保存します、キーはバージョン
+func SavePlanCatalog(ctx context.Context, store Store, colName string, catalog PlanCatalog) error {
+	if err := catalog.Validate(); err != nil {
+		return err
+	}
+	return store.Set(ctx, colName, strconv.Itoa(catalog.Version), catalog)
+}
@@ -0,0 +1,173 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Window names of QuotaStatus.
+const (
+	WindowMonthly = "monthly"
+	WindowDaily   = "daily"
+	WindowHourly  = "hourly"
+)
+
+// QuotaWindowStatus is usage of a window.
+type QuotaWindowStatus struct {
+	Window    string    `json:"window"`
+	Limit     uint16    `json:"limit"`
+	Used      uint16    `json:"used"`
+	Remaining uint16    `json:"remaining"`
+	ResetAt   time.Time `json:"reset_at,omitempty"`
+}
+
+// QuotaStatus is usage of a channel, for API headers and GUI.
+type QuotaStatus struct {
+	Channel string `json:"channel"`
+	// Plan is the effective plan at At.
+	Plan    SubscribedPlan      `json:"plan"`
+	Windows []QuotaWindowStatus `json:"windows"`
+	// Exhausted is a window without remaining, empty if all windows remain.
+	// 複数の期間が上限に達している場合は、最も遅く回復する期間
+	Exhausted string `json:"exhausted,omitempty"`
+	// RetryAt is when the exhausted window resets, zero if not exhausted.
+	RetryAt time.Time `json:"retry_at,omitempty"`
+	// Disabled is true when a window limit is 0, not usable until the plan changes.
+	// 期間のリセットで回復しないため、ExhaustedとRetryAtは設定しない
+	Disabled bool `json:"disabled,omitempty"`
+
+	At time.Time `json:"at"`
+}
+
+// Status チャンネルの期間ごとの残り回数、回復日時を返します
+// 期限を過ぎている場合はダウングレード後の使用制限で計算する
+func (s *Subscribe) Status(name string) QuotaStatus {
+	now := s.now()
+	e := s.effective(s.planCatalogs(), now)
+	status := QuotaStatus{
+		Channel: name,
+		Plan:    e.Plan,
+		At:      now,
+	}
+
+	m := e.Channel(name)
+	strategy := QuotaStrategyOf(m.Strategy)
+	used := strategy.Usage(m, now)
+	resets := strategy.Resets(m, now)
+
+	for _, w := range []QuotaWindowStatus{
+		{Window: WindowMonthly, Limit: m.Limit.Monthly, Used: used.Monthly, ResetAt: resets.Monthly},
+		{Window: WindowDaily, Limit: m.Limit.Daily, Used: used.Daily, ResetAt: resets.Daily},
+		{Window: WindowHourly, Limit: m.Limit.Hourly, Used: used.Hourly, ResetAt: resets.Hourly},
+	} {
+		if w.Used < w.Limit {
+			w.Remaining = w.Limit - w.Used
+		}
+		if w.Limit == 0 {
+			status.Disabled = true
+			status.Windows = append(status.Windows, w)
+			continue
+		}
+		if w.Remaining == 0 && (status.Exhausted == "" || w.ResetAt.After(status.RetryAt)) {
+			status.Exhausted = w.Window
+			status.RetryAt = w.ResetAt
+		}
+		status.Windows = append(status.Windows, w)
+	}
+	if status.Disabled {
+		// 再試行しても使用できない
+		status.Exhausted, status.RetryAt = "", time.Time{}
+	}
+
+	return status
+}
+
+// Window 期間の状態を返します
+func (p QuotaStatus) Window(name string) (QuotaWindowStatus, bool) {
+	for _, v := range p.Windows {
+		if v.Window == name {
+			return v, true
+		}
+	}
+	return QuotaWindowStatus{}, false
+}
+
+// binding 残り回数が最も少ない期間、同数の場合は短い期間を返します
+// 使用制限が0の期間がある場合は、その期間(残り回数は0)
+func (p QuotaStatus) binding() (QuotaWindowStatus, bool) {
+	if p.Exhausted != "" {
+		return p.Window(p.Exhausted)
+	}
+	var found QuotaWindowStatus
+	ok := false
+	for _, v := range p.Windows {
+		if v.Limit == 0 {
+			if p.Disabled {
+				v.Remaining = 0
+				return v, true
+			}
+			continue
+		}
+		if !ok || v.Remaining <= found.Remaining {
+			found, ok = v, true
+		}
+	}
+	return found, ok
+}
+
+// RetryAfter 再試行までの秒数を返します、上限に達していない場合は0
+func (p QuotaStatus) RetryAfter() int {
+	if p.Exhausted == "" || p.RetryAt.IsZero() {
+		return 0
+	}
+	return int(math.Ceil(p.RetryAt.Sub(p.At).Seconds()))
+}
+
+// SetHeader X-RateLimit-*, Retry-Afterヘッダを設定します
+// X-RateLimit-*は残り回数が最も少ない期間の値、X-RateLimit-Resetはunix秒
+// 使用制限が0の期間はLimit, Remainingを0とし、リセットで回復しないためX-RateLimit-Resetは設定しない
+func (p QuotaStatus) SetHeader(h http.Header) {
+	w, ok := p.binding()
+	if !ok {
+		return
+	}
+	h.Set("X-RateLimit-Limit", strconv.Itoa(int(w.Limit)))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(int(w.Remaining)))
+	h.Set("X-RateLimit-Window", w.Window)
+	if !w.ResetAt.IsZero() && w.Limit > 0 {
+		h.Set("X-RateLimit-Reset", strconv.FormatInt(w.ResetAt.Unix(), 10))
+	}
+	if sec := p.RetryAfter(); sec > 0 {
+		h.Set("Retry-After", strconv.Itoa(sec))
+	}
+}
+
+// JSON GUI向けのJSONを返します
+func (p QuotaStatus) JSON() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+// QuotaError is returned by Subscribe.Consume when quota is exceeded.
+// errors.Is(err, ErrQuotaExceeded)で判定できる
+type QuotaError struct {
+	Status QuotaStatus
+}
+
+func (e *QuotaError) Error() string {
+	if e.Status.Disabled {
+		return fmt.Sprintf("%s: %s is not available", ErrQuotaExceeded, e.Status.Channel)
+	}
+	if e.Status.Exhausted == "" {
+		return fmt.Sprintf("%s: %s", ErrQuotaExceeded, e.Status.Channel)
+	}
+	return fmt.Sprintf("%s: %s %s, retry at %s", ErrQuotaExceeded, e.Status.Channel, e.Status.Exhausted, e.Status.RetryAt.Format(time.RFC3339))
+}
+
+// Is for errors.Is
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
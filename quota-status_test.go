@@ -0,0 +1,52 @@
+package models
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuotaStatusSetHeader(t *testing.T) {
+	at := time.Date(2026, 3, 10, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		limit Count
+		want  map[string]string
+	}{
+		{
+			name:  "all limits 0",
+			limit: Count{},
+			want:  map[string]string{"X-RateLimit-Limit": "0", "X-RateLimit-Remaining": "0", "X-RateLimit-Window": WindowMonthly},
+		},
+		{
+			name:  "hourly limit 0",
+			limit: Count{Monthly: 100, Daily: 10},
+			want:  map[string]string{"X-RateLimit-Limit": "0", "X-RateLimit-Remaining": "0", "X-RateLimit-Window": WindowHourly},
+		},
+		{
+			name:  "enabled",
+			limit: Count{Monthly: 100, Daily: 10, Hourly: 3},
+			want: map[string]string{
+				"X-RateLimit-Limit": "3", "X-RateLimit-Remaining": "3", "X-RateLimit-Window": WindowHourly,
+				"X-RateLimit-Reset": "1773147600",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newQuotaSubscribe(StrategyCalendar, tt.limit, NewFixedClock(at))
+			h := http.Header{}
+			s.Status(ChannelAPI).SetHeader(h)
+
+			want := http.Header{}
+			for k, v := range tt.want {
+				want.Set(k, v)
+			}
+			assert.Equal(t, want, h)
+		})
+	}
+}
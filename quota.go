@@ -0,0 +1,409 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+// Clock returns current time, replaced in tests.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc is a function as Clock.
+type ClockFunc func() time.Time
+
+// Now for interface
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// SystemClock is time.Now.
+var SystemClock Clock = ClockFunc(time.Now)
+
+// FixedClock returns the same time until Set or Add.
+type FixedClock struct {
+	t time.Time
+}
+
+// NewFixedClock is constructor
+func NewFixedClock(t time.Time) *FixedClock {
+	return &FixedClock{t: t}
+}
+
+// Now for interface
+func (p *FixedClock) Now() time.Time {
+	return p.t
+}
+
+// Set 時刻を設定します
+func (p *FixedClock) Set(t time.Time) {
+	p.t = t
+}
+
+// Add 時刻を進めます
+func (p *FixedClock) Add(d time.Duration) {
+	p.t = p.t.Add(d)
+}
+
+// Names of QuotaStrategy, stored in Managed.Strategy.
+const (
+	StrategyCalendar       = "calendar"
+	StrategySlidingLog     = "sliding_log"
+	StrategySlidingCounter = "sliding_counter"
+	StrategyTokenBucket    = "token_bucket"
+)
+
+// Window lengths of sliding strategies.
+// カレンダー以外の方式では、月は30日として扱う
+const (
+	QuotaHour  = time.Hour
+	QuotaDay   = 24 * time.Hour
+	QuotaMonth = 30 * QuotaDay
+)
+
+// QuotaStrategy counts usage of Managed.
+// 使用回数 + n が使用制限以下の場合のみ使用できる、使用制限が0の期間は常に使用できない
+// Consumeは制限を超えても記録する、制限の確認はAllowで行う
+type QuotaStrategy interface {
+	// Consume n回の使用を記録します
+	Consume(m *Managed, n uint16, now time.Time)
+	// Allow n回分を使用できるかを返します
+	Allow(m Managed, n uint16, now time.Time) bool
+	// Usage 指定時刻時点の期間ごとの使用回数を返します
+	Usage(m Managed, now time.Time) Count
+	// Resets 期間ごとに次に使用回数が減る日時を返します
+	Resets(m Managed, now time.Time) QuotaResets
+}
+
+// QuotaResets is reset times per window.
+type QuotaResets struct {
+	Monthly time.Time `json:"monthly"`
+	Daily   time.Time `json:"daily"`
+	Hourly  time.Time `json:"hourly"`
+}
+
+func newQuotaResets(times []time.Time) QuotaResets {
+	return QuotaResets{Monthly: times[0], Daily: times[1], Hourly: times[2]}
+}
+
+// QuotaStrategyOf 名前からQuotaStrategyを返します、不明な名前はCalendarQuota
+func QuotaStrategyOf(name string) QuotaStrategy {
+	switch name {
+	case StrategySlidingLog:
+		return SlidingLogQuota{}
+	case StrategySlidingCounter:
+		return SlidingCounterQuota{}
+	case StrategyTokenBucket:
+		return TokenBucketQuota{}
+	}
+	return CalendarQuota{}
+}
+
+// quotaWindow is a window of Managed, hourly, daily and monthly.
+type quotaWindow struct {
+	length   time.Duration
+	limit    uint16
+	used     *uint16
+	previous *uint16
+	tokens   *float64
+	same     func(a, b time.Time) bool
+}
+
+func (m *Managed) windows() []quotaWindow {
+	return []quotaWindow{
+		{length: QuotaMonth, limit: m.Limit.Monthly, used: &m.Used.Monthly, previous: &m.Previous.Monthly, tokens: &m.Tokens.Monthly, same: sameMonth},
+		{length: QuotaDay, limit: m.Limit.Daily, used: &m.Used.Daily, previous: &m.Previous.Daily, tokens: &m.Tokens.Daily, same: sameDay},
+		{length: QuotaHour, limit: m.Limit.Hourly, used: &m.Used.Hourly, previous: &m.Previous.Hourly, tokens: &m.Tokens.Hourly, same: sameHour},
+	}
+}
+
+func sameMonth(a, b time.Time) bool {
+	return a.Year() == b.Year() && a.Month() == b.Month()
+}
+
+func sameDay(a, b time.Time) bool {
+	return sameMonth(a, b) && a.Day() == b.Day()
+}
+
+func sameHour(a, b time.Time) bool {
+	return sameDay(a, b) && a.Hour() == b.Hour()
+}
+
+func addCount(v *uint16, n uint16) {
+	if math.MaxUint16-*v < n {
+		*v = math.MaxUint16
+		return
+	}
+	*v += n
+}
+
+// CalendarQuota counts usage per calendar month, day and hour in the location of now.
+// 年も比較するため、翌年の同じ月でもリセットされる
+type CalendarQuota struct{}
+
+// reset 最終使用日時と異なる期間の使用回数をリセットします
+func (CalendarQuota) reset(m *Managed, now time.Time) {
+	if m.LastUsedAt.IsZero() {
+		return
+	}
+	last := m.LastUsedAt.In(now.Location())
+	for _, w := range m.windows() {
+		if !w.same(last, now) {
+			*w.used = 0
+		}
+	}
+}
+
+// Consume for interface
+func (p CalendarQuota) Consume(m *Managed, n uint16, now time.Time) {
+	p.reset(m, now)
+	for _, w := range m.windows() {
+		addCount(w.used, n)
+	}
+	m.LastUsedAt = now
+}
+
+// Allow for interface
+func (p CalendarQuota) Allow(m Managed, n uint16, now time.Time) bool {
+	p.reset(&m, now)
+	return allowWindows(m, n)
+}
+
+// allowWindows 全ての期間で使用回数 + n が使用制限以下かを返します
+// 使用制限が0の期間はnが0でも使用できない
+func allowWindows(m Managed, n uint16) bool {
+	for _, w := range m.windows() {
+		if w.limit == 0 || int(*w.used)+int(n) > int(w.limit) {
+			return false
+		}
+	}
+	return true
+}
+
+// Usage for interface
+func (p CalendarQuota) Usage(m Managed, now time.Time) Count {
+	p.reset(&m, now)
+	return m.Used
+}
+
+// Resets for interface, 次の月・日・時の開始時刻
+func (p CalendarQuota) Resets(m Managed, now time.Time) QuotaResets {
+	hour := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, now.Location())
+	return QuotaResets{
+		Monthly: time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, now.Location()),
+		Daily:   time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location()),
+		Hourly:  hour.Add(time.Hour),
+	}
+}
+
+// SlidingLogQuota records each usage and counts usage in the last hour, day and 30 days.
+// 正確だが、月の制限回数分の記録をドキュメントに保持する
+type SlidingLogQuota struct{}
+
+// prune 30日より前の記録を削除し、Usedを集計します
+func (SlidingLogQuota) prune(m *Managed, now time.Time) {
+	log := m.Log[:0]
+	for _, v := range m.Log {
+		if now.Sub(v.At) < QuotaMonth {
+			log = append(log, v)
+		}
+	}
+	m.Log = log
+
+	for _, w := range m.windows() {
+		*w.used = 0
+		for _, v := range m.Log {
+			if now.Sub(v.At) < w.length {
+				addCount(w.used, v.N)
+			}
+		}
+	}
+}
+
+// Consume for interface
+func (p SlidingLogQuota) Consume(m *Managed, n uint16, now time.Time) {
+	m.Log = append(m.Log, QuotaEntry{At: now, N: n})
+	p.prune(m, now)
+	m.LastUsedAt = now
+}
+
+// Allow for interface
+func (p SlidingLogQuota) Allow(m Managed, n uint16, now time.Time) bool {
+	m.Log = append([]QuotaEntry{}, m.Log...)
+	p.prune(&m, now)
+	return allowWindows(m, n)
+}
+
+// Usage for interface
+func (p SlidingLogQuota) Usage(m Managed, now time.Time) Count {
+	m.Log = append([]QuotaEntry{}, m.Log...)
+	p.prune(&m, now)
+	return m.Used
+}
+
+// Resets for interface, 期間内で最も古い記録が期間外になる日時、記録が無い場合はnow
+func (p SlidingLogQuota) Resets(m Managed, now time.Time) QuotaResets {
+	times := []time.Time{now, now, now}
+	for i, length := range []time.Duration{QuotaMonth, QuotaDay, QuotaHour} {
+		for _, v := range m.Log {
+			if now.Sub(v.At) >= length {
+				continue
+			}
+			if reset := v.At.Add(length); times[i].Equal(now) || reset.Before(times[i]) {
+				times[i] = reset
+			}
+		}
+	}
+	return newQuotaResets(times)
+}
+
+// SlidingCounterQuota estimates usage in the sliding window from the current and previous fixed windows.
+// 直前の期間の使用回数を経過時間で按分するため、期間の境界をまたいだ2倍の使用を防ぐ
+type SlidingCounterQuota struct{}
+
+// roll 期間が進んだ場合、現在の期間の使用回数を直前の期間に移します
+func (SlidingCounterQuota) roll(m *Managed, now time.Time) {
+	if m.LastUsedAt.IsZero() {
+		return
+	}
+	for _, w := range m.windows() {
+		last, cur := m.LastUsedAt.Truncate(w.length), now.Truncate(w.length)
+		switch {
+		case !cur.After(last):
+		case cur.Sub(last) == w.length:
+			*w.previous, *w.used = *w.used, 0
+		default:
+			*w.previous, *w.used = 0, 0
+		}
+	}
+}
+
+// Consume for interface
+func (p SlidingCounterQuota) Consume(m *Managed, n uint16, now time.Time) {
+	p.roll(m, now)
+	for _, w := range m.windows() {
+		addCount(w.used, n)
+	}
+	m.LastUsedAt = now
+}
+
+// estimate 直前の期間の使用回数を経過時間で按分し、現在の期間の使用回数と合算します
+func (p SlidingCounterQuota) estimate(m Managed, now time.Time) []float64 {
+	p.roll(&m, now)
+	windows := m.windows()
+	estimates := make([]float64, len(windows))
+	for i, w := range windows {
+		elapsed := now.Sub(now.Truncate(w.length))
+		weight := 1 - float64(elapsed)/float64(w.length)
+		estimates[i] = float64(*w.previous)*weight + float64(*w.used)
+	}
+	return estimates
+}
+
+// Allow for interface
+func (p SlidingCounterQuota) Allow(m Managed, n uint16, now time.Time) bool {
+	estimates := p.estimate(m, now)
+	for i, w := range m.windows() {
+		if w.limit == 0 || estimates[i]+float64(n) > float64(w.limit) {
+			return false
+		}
+	}
+	return true
+}
+
+// Usage for interface, 按分した値は切り上げる
+func (p SlidingCounterQuota) Usage(m Managed, now time.Time) Count {
+	e := p.estimate(m, now)
+	return Count{
+		Monthly: clampCount(math.Ceil(e[0])),
+		Daily:   clampCount(math.Ceil(e[1])),
+		Hourly:  clampCount(math.Ceil(e[2])),
+	}
+}
+
+// Resets for interface, 次の期間の開始時刻
+func (p SlidingCounterQuota) Resets(m Managed, now time.Time) QuotaResets {
+	times := []time.Time{}
+	for _, length := range []time.Duration{QuotaMonth, QuotaDay, QuotaHour} {
+		times = append(times, now.Truncate(length).Add(length))
+	}
+	return newQuotaResets(times)
+}
+
+func clampCount(v float64) uint16 {
+	return uint16(math.Max(0, math.Min(math.MaxUint16, v)))
+}
+
+// TokenBucketQuota refills tokens continuously, limit per window is the bucket size.
+// e.g. 時間あたり10回は、最大10回まで連続して使用でき、6分ごとに1回分回復する
+type TokenBucketQuota struct{}
+
+// refill 最終使用日時からの経過時間分を回復します、初回は満タン
+func (TokenBucketQuota) refill(m *Managed, now time.Time) {
+	for _, w := range m.windows() {
+		size := float64(w.limit)
+		if m.LastUsedAt.IsZero() {
+			*w.tokens = size
+			continue
+		}
+		elapsed := now.Sub(m.LastUsedAt)
+		if elapsed <= 0 {
+			continue
+		}
+		*w.tokens = math.Min(size, *w.tokens+size*float64(elapsed)/float64(w.length))
+	}
+}
+
+// Consume for interface
+func (p TokenBucketQuota) Consume(m *Managed, n uint16, now time.Time) {
+	p.refill(m, now)
+	for _, w := range m.windows() {
+		*w.tokens -= float64(n)
+	}
+	m.LastUsedAt = now
+}
+
+// Allow for interface
+func (p TokenBucketQuota) Allow(m Managed, n uint16, now time.Time) bool {
+	p.refill(&m, now)
+	for _, w := range m.windows() {
+		if w.limit == 0 || *w.tokens < float64(n) {
+			return false
+		}
+	}
+	return true
+}
+
+// Usage for interface, 使用回数は消費済みのトークン数
+func (p TokenBucketQuota) Usage(m Managed, now time.Time) Count {
+	p.refill(&m, now)
+	used := func(limit uint16, tokens float64) uint16 {
+		return clampCount(math.Ceil(float64(limit) - tokens))
+	}
+	return Count{
+		Monthly: used(m.Limit.Monthly, m.Tokens.Monthly),
+		Daily:   used(m.Limit.Daily, m.Tokens.Daily),
+		Hourly:  used(m.Limit.Hourly, m.Tokens.Hourly),
+	}
+}
+
+// Resets for interface, 1回分のトークンが回復する日時、トークンがある場合はnow
+// 使用制限が0の期間は回復しないためゼロ値
+func (p TokenBucketQuota) Resets(m Managed, now time.Time) QuotaResets {
+	p.refill(&m, now)
+	times := []time.Time{}
+	for _, w := range m.windows() {
+		switch {
+		case w.limit == 0:
+			times = append(times, time.Time{})
+			continue
+		case *w.tokens >= 1:
+			times = append(times, now)
+			continue
+		}
+		wait := (1 - *w.tokens) * float64(w.length) / float64(w.limit)
+		times = append(times, now.Add(time.Duration(math.Ceil(wait))))
+	}
+	return newQuotaResets(times)
+}
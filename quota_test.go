@@ -0,0 +1,281 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var quotaStrategies = []string{StrategyCalendar, StrategySlidingLog, StrategySlidingCounter, StrategyTokenBucket}
+
+// newQuotaSubscribe 指定した方式と使用制限のapiチャンネルを持つSubscribe
+func newQuotaSubscribe(strategy string, limit Count, clock Clock) *Subscribe {
+	s := NewSubscribe().WithClock(clock)
+	s.setChannel(ChannelAPI, Managed{Limit: limit, Strategy: strategy})
+	return s
+}
+
+func TestQuotaLimit(t *testing.T) {
+	at := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		limit Count
+		used  uint16
+		n     uint16
+		want  bool
+	}{
+		{name: "limit 0", limit: Count{}, n: 1, want: false},
+		{name: "hourly limit 0", limit: Count{Monthly: 100, Daily: 10}, n: 1, want: false},
+		{name: "limit 0, n 0", limit: Count{}, n: 0, want: false},
+		{name: "hourly limit 0, n 0", limit: Count{Monthly: 100, Daily: 10}, n: 0, want: false},
+		{name: "used limit, n 0", limit: Count{Monthly: 100, Daily: 10, Hourly: 3}, used: 3, n: 0, want: true},
+		{name: "used limit-1, n 1", limit: Count{Monthly: 100, Daily: 10, Hourly: 3}, used: 2, n: 1, want: true},
+		{name: "used limit-1, n 2", limit: Count{Monthly: 100, Daily: 10, Hourly: 3}, used: 2, n: 2, want: false},
+		{name: "used limit, n 1", limit: Count{Monthly: 100, Daily: 10, Hourly: 3}, used: 3, n: 1, want: false},
+		{name: "unused, n limit", limit: Count{Monthly: 100, Daily: 10, Hourly: 3}, n: 3, want: true},
+	}
+
+	for _, strategy := range quotaStrategies {
+		for _, tt := range tests {
+			t.Run(strategy+"/"+tt.name, func(t *testing.T) {
+				clock := NewFixedClock(at)
+				s := newQuotaSubscribe(strategy, tt.limit, clock)
+				if tt.used > 0 {
+					m := s.Channel(ChannelAPI)
+					QuotaStrategyOf(strategy).Consume(&m, tt.used, clock.Now())
+					s.setChannel(ChannelAPI, m)
+				}
+
+				assert.Equal(t, tt.want, s.Allow(ChannelAPI, tt.n))
+
+				err := s.Consume(ChannelAPI, tt.n)
+				if tt.want {
+					assert.NoError(t, err)
+				} else {
+					assert.ErrorIs(t, err, ErrQuotaExceeded)
+				}
+			})
+		}
+	}
+}
+
+func TestQuotaIncrementIsLimit(t *testing.T) {
+	at := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		limit      Count
+		increments int
+		want       bool
+	}{
+		{name: "limit 0", limit: Count{}, want: true},
+		{name: "unused", limit: Count{Monthly: 100, Daily: 10, Hourly: 3}, want: false},
+		{name: "limit-1", limit: Count{Monthly: 100, Daily: 10, Hourly: 3}, increments: 2, want: false},
+		{name: "limit", limit: Count{Monthly: 100, Daily: 10, Hourly: 3}, increments: 3, want: true},
+		{name: "over limit", limit: Count{Monthly: 100, Daily: 10, Hourly: 3}, increments: 5, want: true},
+		{name: "daily limit", limit: Count{Monthly: 100, Daily: 2, Hourly: 3}, increments: 2, want: true},
+	}
+
+	for _, strategy := range quotaStrategies {
+		for _, tt := range tests {
+			t.Run(strategy+"/"+tt.name, func(t *testing.T) {
+				s := newQuotaSubscribe(strategy, tt.limit, NewFixedClock(at))
+				for i := 0; i < tt.increments; i++ {
+					s.Increment(true)
+				}
+				assert.Equal(t, tt.want, s.IsLimit(true))
+			})
+		}
+	}
+}
+
+func TestQuotaRollover(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit Count
+		from  time.Time
+		to    time.Time
+		// want is Allow(1) at to, after the limit is used at from.
+		want map[string]bool
+	}{
+		{
+			name:  "23:59 to 00:00",
+			limit: Count{Monthly: 100, Daily: 2, Hourly: 10},
+			from:  time.Date(2026, 3, 10, 23, 59, 0, 0, time.UTC),
+			to:    time.Date(2026, 3, 11, 0, 0, 0, 0, time.UTC),
+			want: map[string]bool{
+				StrategyCalendar:       true,
+				StrategySlidingLog:     false,
+				StrategySlidingCounter: false,
+				StrategyTokenBucket:    false,
+			},
+		},
+		{
+			name:  "hour 23:59 to 00:00",
+			limit: Count{Monthly: 100, Daily: 10, Hourly: 2},
+			from:  time.Date(2026, 3, 10, 23, 59, 0, 0, time.UTC),
+			to:    time.Date(2026, 3, 11, 0, 0, 0, 0, time.UTC),
+			want: map[string]bool{
+				StrategyCalendar:       true,
+				StrategySlidingLog:     false,
+				StrategySlidingCounter: false,
+				StrategyTokenBucket:    false,
+			},
+		},
+		{
+			name:  "a day after 23:59",
+			limit: Count{Monthly: 100, Daily: 2, Hourly: 10},
+			from:  time.Date(2026, 3, 10, 23, 59, 0, 0, time.UTC),
+			to:    time.Date(2026, 3, 12, 0, 0, 0, 0, time.UTC),
+			want: map[string]bool{
+				StrategyCalendar:       true,
+				StrategySlidingLog:     true,
+				StrategySlidingCounter: true,
+				StrategyTokenBucket:    true,
+			},
+		},
+		{
+			name:  "month end",
+			limit: Count{Monthly: 2, Daily: 10, Hourly: 10},
+			from:  time.Date(2026, 1, 31, 23, 59, 0, 0, time.UTC),
+			to:    time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+			want: map[string]bool{
+				StrategyCalendar:       true,
+				StrategySlidingLog:     false,
+				StrategySlidingCounter: false,
+				StrategyTokenBucket:    false,
+			},
+		},
+		{
+			name:  "month end of february",
+			limit: Count{Monthly: 2, Daily: 10, Hourly: 10},
+			from:  time.Date(2026, 2, 28, 23, 59, 0, 0, time.UTC),
+			to:    time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+			want: map[string]bool{
+				StrategyCalendar:       true,
+				StrategySlidingLog:     false,
+				StrategySlidingCounter: false,
+				StrategyTokenBucket:    false,
+			},
+		},
+		{
+			name:  "dec to jan",
+			limit: Count{Monthly: 2, Daily: 10, Hourly: 10},
+			from:  time.Date(2026, 12, 31, 23, 59, 0, 0, time.UTC),
+			to:    time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+			want: map[string]bool{
+				StrategyCalendar:       true,
+				StrategySlidingLog:     false,
+				StrategySlidingCounter: false,
+				StrategyTokenBucket:    false,
+			},
+		},
+		{
+			name:  "same month of next year",
+			limit: Count{Monthly: 2, Daily: 10, Hourly: 10},
+			from:  time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC),
+			to:    time.Date(2027, 1, 15, 12, 0, 0, 0, time.UTC),
+			want: map[string]bool{
+				StrategyCalendar:       true,
+				StrategySlidingLog:     true,
+				StrategySlidingCounter: true,
+				StrategyTokenBucket:    true,
+			},
+		},
+		{
+			name:  "same hour",
+			limit: Count{Monthly: 100, Daily: 10, Hourly: 2},
+			from:  time.Date(2026, 3, 10, 23, 0, 0, 0, time.UTC),
+			to:    time.Date(2026, 3, 10, 23, 59, 0, 0, time.UTC),
+			want: map[string]bool{
+				StrategyCalendar:       false,
+				StrategySlidingLog:     false,
+				StrategySlidingCounter: false,
+				// 59分で1回分以上回復する
+				StrategyTokenBucket: true,
+			},
+		},
+	}
+
+	for _, strategy := range quotaStrategies {
+		for _, tt := range tests {
+			t.Run(strategy+"/"+tt.name, func(t *testing.T) {
+				clock := NewFixedClock(tt.from)
+				s := newQuotaSubscribe(strategy, tt.limit, clock)
+				for s.Allow(ChannelAPI, 1) {
+					require.NoError(t, s.Consume(ChannelAPI, 1))
+				}
+
+				clock.Set(tt.to)
+				assert.Equal(t, tt.want[strategy], s.Allow(ChannelAPI, 1))
+			})
+		}
+	}
+}
+
+func TestCalendarQuotaResets(t *testing.T) {
+	tests := []struct {
+		name string
+		now  time.Time
+		want QuotaResets
+	}{
+		{
+			name: "23:59",
+			now:  time.Date(2026, 3, 10, 23, 59, 0, 0, time.UTC),
+			want: QuotaResets{
+				Monthly: time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC),
+				Daily:   time.Date(2026, 3, 11, 0, 0, 0, 0, time.UTC),
+				Hourly:  time.Date(2026, 3, 11, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "month end",
+			now:  time.Date(2026, 1, 31, 23, 59, 0, 0, time.UTC),
+			want: QuotaResets{
+				Monthly: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+				Daily:   time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+				Hourly:  time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "dec to jan",
+			now:  time.Date(2026, 12, 31, 23, 59, 0, 0, time.UTC),
+			want: QuotaResets{
+				Monthly: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+				Daily:   time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+				Hourly:  time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFixedClock(tt.now)
+			got := CalendarQuota{}.Resets(Managed{}, clock.Now())
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSubscribeStatusRollover(t *testing.T) {
+	clock := NewFixedClock(time.Date(2026, 12, 31, 23, 59, 0, 0, time.UTC))
+	s := newQuotaSubscribe(StrategyCalendar, Count{Monthly: 2, Daily: 10, Hourly: 10}, clock)
+	require.NoError(t, s.Consume(ChannelAPI, 2))
+
+	status := s.Status(ChannelAPI)
+	assert.Equal(t, WindowMonthly, status.Exhausted)
+	assert.Equal(t, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC), status.RetryAt)
+	assert.Equal(t, 60, status.RetryAfter())
+
+	clock.Add(time.Minute)
+	status = s.Status(ChannelAPI)
+	assert.Empty(t, status.Exhausted)
+	assert.Zero(t, status.RetryAfter())
+
+	w, ok := status.Window(WindowMonthly)
+	require.True(t, ok)
+	assert.Equal(t, uint16(2), w.Remaining)
+}
@@ -0,0 +1,385 @@
+package models
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var ErrRedis = errors.New("redis error")
+
+// DefaultRedisPoolSize is max connections of RedisRateLimiter when PoolSize is 0.
+const DefaultRedisPoolSize = 4
+
+// RedisRateLimiter is a RateLimiter over the Redis protocol (RESP).
+// Redis互換のサーバー(miniredis等)でも動作する、コマンドはEVAL/GETのみ使用する
+// 確認と加算はLuaスクリプトで1回のEVALとして実行し、同時に実行されても他のコマンドが割り込まない
+type RedisRateLimiter struct {
+	Addr     string
+	Password string
+	DB       int
+	// Timeout is a dial and command timeout if ctx has no deadline.
+	Timeout time.Duration
+	// PoolSize is max connections, DefaultRedisPoolSize if 0.
+	// 上限まで同時にコマンドを送信し、超えた分は接続が空くまで待つ
+	PoolSize int
+
+	once sync.Once
+	sem  chan struct{}
+
+	mu     sync.Mutex
+	idle   []*redisConn
+	closed bool
+}
+
+// redisConn is a connection of RedisRateLimiter pool.
+type redisConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+}
+
+// NewRedisRateLimiter is constructor
+func NewRedisRateLimiter(addr string) *RedisRateLimiter {
+	return &RedisRateLimiter{
+		Addr:     addr,
+		Timeout:  3 * time.Second,
+		PoolSize: DefaultRedisPoolSize,
+	}
+}
+
+// Close 待機中の接続を閉じます、使用中の接続は返却時に閉じる
+func (p *RedisRateLimiter) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var err error
+	for _, c := range p.idle {
+		if cerr := c.conn.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	p.idle, p.closed = nil, true
+	return err
+}
+
+// acquire 接続を取り出します、待機中の接続が無い場合は接続する
+func (p *RedisRateLimiter) acquire(ctx context.Context) (*redisConn, error) {
+	p.once.Do(func() {
+		size := p.PoolSize
+		if size <= 0 {
+			size = DefaultRedisPoolSize
+		}
+		p.sem = make(chan struct{}, size)
+	})
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.sem
+		return nil, fmt.Errorf("%w: rate limiter is closed", ErrRedis)
+	}
+	if n := len(p.idle); n > 0 {
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return c, nil
+	}
+	p.mu.Unlock()
+
+	c, err := p.dial(ctx)
+	if err != nil {
+		<-p.sem
+		return nil, err
+	}
+	return c, nil
+}
+
+// release 接続を返却します、brokenの場合は破棄し、次のコマンドで再接続する
+func (p *RedisRateLimiter) release(c *redisConn, broken bool) {
+	defer func() { <-p.sem }()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if broken || p.closed {
+		c.conn.Close()
+		return
+	}
+	p.idle = append(p.idle, c)
+}
+
+// dial 接続し、AUTH, SELECTを送信します
+func (p *RedisRateLimiter) dial(ctx context.Context) (*redisConn, error) {
+	d := net.Dialer{Timeout: p.Timeout}
+	conn, err := d.DialContext(ctx, "tcp", p.Addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn), timeout: p.Timeout}
+
+	if p.Password != "" {
+		if _, err := c.send(ctx, "AUTH", p.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if p.DB != 0 {
+		if _, err := c.send(ctx, "SELECT", strconv.Itoa(p.DB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// do コマンドを送信し、応答を返します
+// 通信に失敗した場合は接続を破棄し、次のコマンドで再接続する
+func (p *RedisRateLimiter) do(ctx context.Context, args ...string) (any, error) {
+	c, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v, err := c.send(ctx, args...)
+	p.release(c, err != nil && !errors.Is(err, ErrRedis))
+	return v, err
+}
+
+func (c *redisConn) send(ctx context.Context, args ...string) (any, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok && c.timeout > 0 {
+		deadline = time.Now().Add(c.timeout)
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"+arg+"\r\n"...)
+	}
+	if _, err := c.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return readReply(c.r)
+}
+
+// readReply RESPの応答を読み込みます
+// simple string, bulk stringはstring, integerはint64, nilはnil, arrayは[]any
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("%w: invalid reply %q", ErrRedis, line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, fmt.Errorf("%w: %s", ErrRedis, body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		values := make([]any, n)
+		for i := range values {
+			if values[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("%w: unknown reply %q", ErrRedis, line)
+}
+
+// Lua scripts of RedisRateLimiter, KEYS[1] is a key.
+// 期限のunixミリ秒が0の場合は期限を設定しない
+const (
+	// ARGV: n, limit, expireAt. returns {count, 1 if taken}
+	redisTakeScript = `local count = tonumber(redis.call('GET', KEYS[1]) or '0')
+local n = tonumber(ARGV[1])
+if count + n > tonumber(ARGV[2]) then
+	return {count, 0}
+end
+count = redis.call('INCRBY', KEYS[1], n)
+if tonumber(ARGV[3]) > 0 then
+	redis.call('PEXPIREAT', KEYS[1], ARGV[3])
+end
+return {count, 1}`
+
+	// ARGV: n. returns count
+	redisReleaseScript = `local count = tonumber(redis.call('GET', KEYS[1]) or '0')
+if count == 0 then
+	return 0
+end
+count = redis.call('DECRBY', KEYS[1], ARGV[1])
+if count <= 0 then
+	redis.call('DEL', KEYS[1])
+	return 0
+end
+return count`
+
+	// ARGV: count, expireAt. returns count
+	redisSetScript = `redis.call('SET', KEYS[1], ARGV[1])
+if tonumber(ARGV[2]) > 0 then
+	redis.call('PEXPIREAT', KEYS[1], ARGV[2])
+end
+return tonumber(ARGV[1])`
+)
+
+// eval スクリプトを実行します、スクリプトのキャッシュ(EVALSHA)は使用しない
+func (p *RedisRateLimiter) eval(ctx context.Context, script, key string, args ...string) (any, error) {
+	return p.do(ctx, append([]string{"EVAL", script, "1", key}, args...)...)
+}
+
+// unixMilli ゼロ値は0
+func unixMilli(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+// Take for interface
+// 確認、加算、期限の設定を1回のEVALで行うため、同時に加算された場合も許可された合計はlimitを超えない
+func (p *RedisRateLimiter) Take(ctx context.Context, key string, n, limit int64, expireAt time.Time) (int64, bool, error) {
+	v, err := p.eval(ctx, redisTakeScript, key, strconv.FormatInt(n, 10), strconv.FormatInt(limit, 10), unixMilli(expireAt))
+	if err != nil {
+		return 0, false, err
+	}
+	values, ok := v.([]any)
+	if !ok || len(values) != 2 {
+		return 0, false, fmt.Errorf("%w: unexpected reply %v", ErrRedis, v)
+	}
+	count, ok1 := values[0].(int64)
+	taken, ok2 := values[1].(int64)
+	if !ok1 || !ok2 {
+		return 0, false, fmt.Errorf("%w: unexpected reply %v", ErrRedis, v)
+	}
+	return count, taken == 1, nil
+}
+
+// Release for interface
+// 0以下になった場合(期限切れ後に戻した場合)は削除する
+func (p *RedisRateLimiter) Release(ctx context.Context, key string, n int64) error {
+	_, err := p.eval(ctx, redisReleaseScript, key, strconv.FormatInt(n, 10))
+	return err
+}
+
+// Get for interface
+func (p *RedisRateLimiter) Get(ctx context.Context, key string) (int64, error) {
+	v, err := p.do(ctx, "GET", key)
+	if err != nil {
+		return 0, err
+	}
+	switch v := v.(type) {
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case nil:
+		return 0, nil
+	}
+	return 0, fmt.Errorf("%w: unexpected reply %v", ErrRedis, v)
+}
+
+// Set for interface
+func (p *RedisRateLimiter) Set(ctx context.Context, key string, count int64, expireAt time.Time) error {
+	_, err := p.eval(ctx, redisSetScript, key, strconv.FormatInt(count, 10), unixMilli(expireAt))
+	return err
+}
+
+// FallbackRateLimiter uses Secondary while Primary fails.
+// 障害中はプロセスごとに制限するため、複数プロセスの合計は使用制限を超えることがある
+// 障害中の使用回数はReconcileSubscribesでPrimaryに反映される
+type FallbackRateLimiter struct {
+	Primary   RateLimiter
+	Secondary RateLimiter
+}
+
+// NewFallbackRateLimiter is constructor, Secondary is MemoryRateLimiter.
+func NewFallbackRateLimiter(primary RateLimiter) *FallbackRateLimiter {
+	return &FallbackRateLimiter{
+		Primary:   primary,
+		Secondary: NewMemoryRateLimiter(),
+	}
+}
+
+func (p *FallbackRateLimiter) fallback(function, key string, err error) {
+	log.Warn().Err(err).Str("function", function).Msgf("rate limiter fallback: %s", key)
+}
+
+// Take for interface
+func (p *FallbackRateLimiter) Take(ctx context.Context, key string, n, limit int64, expireAt time.Time) (int64, bool, error) {
+	count, ok, err := p.Primary.Take(ctx, key, n, limit, expireAt)
+	if err == nil {
+		return count, ok, nil
+	}
+	p.fallback("FallbackRateLimiter.Take", key, err)
+	return p.Secondary.Take(ctx, key, n, limit, expireAt)
+}
+
+// Release for interface
+func (p *FallbackRateLimiter) Release(ctx context.Context, key string, n int64) error {
+	if err := p.Primary.Release(ctx, key, n); err != nil {
+		p.fallback("FallbackRateLimiter.Release", key, err)
+		return p.Secondary.Release(ctx, key, n)
+	}
+	return nil
+}
+
+// Get for interface
+func (p *FallbackRateLimiter) Get(ctx context.Context, key string) (int64, error) {
+	count, err := p.Primary.Get(ctx, key)
+	if err != nil {
+		p.fallback("FallbackRateLimiter.Get", key, err)
+		return p.Secondary.Get(ctx, key)
+	}
+	return count, nil
+}
+
+// Set for interface
+func (p *FallbackRateLimiter) Set(ctx context.Context, key string, count int64, expireAt time.Time) error {
+	if err := p.Secondary.Set(ctx, key, count, expireAt); err != nil {
+		return err
+	}
+	if err := p.Primary.Set(ctx, key, count, expireAt); err != nil {
+		p.fallback("FallbackRateLimiter.Set", key, err)
+	}
+	return nil
+}
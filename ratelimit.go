@@ -0,0 +1,287 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var ErrQuotaStrategy = errors.New("quota strategy is not supported")
+
+// errUnchanged 照合の結果、Subscribeを保存しない
+var errUnchanged = errors.New("subscribe is not changed")
+
+// RateLimiter is a shared counter store for quota checks on each request.
+// キーに期間の開始時刻を含めるため、期間ごとに別のカウンタとなる
+type RateLimiter interface {
+	// Take keyの使用回数にnを加算します
+	// 加算後にlimitを超える場合は加算せずfalseを返す、expireAtを過ぎたカウンタは削除される
+	Take(ctx context.Context, key string, n, limit int64, expireAt time.Time) (count int64, ok bool, err error)
+	// Release Takeで加算したn回分を戻します
+	Release(ctx context.Context, key string, n int64) error
+	// Get 使用回数を返します、無い場合は0
+	Get(ctx context.Context, key string) (int64, error)
+	// Set 使用回数を設定します、Firestoreとの照合に使用する
+	Set(ctx context.Context, key string, count int64, expireAt time.Time) error
+}
+
+// MemoryRateLimiter is an in-process RateLimiter.
+// 単一プロセスの場合、またはRedisが使用できない場合のfallbackとして使用する
+type MemoryRateLimiter struct {
+	Clock Clock
+
+	mu       sync.Mutex
+	counters map[string]memoryCounter
+}
+
+type memoryCounter struct {
+	count    int64
+	expireAt time.Time
+}
+
+// NewMemoryRateLimiter is constructor
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{
+		Clock:    SystemClock,
+		counters: make(map[string]memoryCounter),
+	}
+}
+
+// counter 期限切れのカウンタは削除して返します、呼び出し側でロックすること
+func (p *MemoryRateLimiter) counter(key string) memoryCounter {
+	v, ok := p.counters[key]
+	if ok && !v.expireAt.IsZero() && !p.Clock.Now().Before(v.expireAt) {
+		delete(p.counters, key)
+		return memoryCounter{}
+	}
+	return v
+}
+
+// Take for interface
+func (p *MemoryRateLimiter) Take(ctx context.Context, key string, n, limit int64, expireAt time.Time) (int64, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	v := p.counter(key)
+	if v.count+n > limit {
+		return v.count, false, nil
+	}
+	v.count += n
+	v.expireAt = expireAt
+	p.counters[key] = v
+	return v.count, true, nil
+}
+
+// Release for interface
+func (p *MemoryRateLimiter) Release(ctx context.Context, key string, n int64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	v := p.counter(key)
+	if v.count == 0 {
+		return nil
+	}
+	v.count = max(0, v.count-n)
+	p.counters[key] = v
+	return nil
+}
+
+// Get for interface
+func (p *MemoryRateLimiter) Get(ctx context.Context, key string) (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.counter(key).count, nil
+}
+
+// Set for interface
+func (p *MemoryRateLimiter) Set(ctx context.Context, key string, count int64, expireAt time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.counters[key] = memoryCounter{count: count, expireAt: expireAt}
+	return nil
+}
+
+// Sweep 期限切れのカウンタを削除します
+func (p *MemoryRateLimiter) Sweep() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := len(p.counters)
+	for k := range p.counters {
+		p.counter(k)
+	}
+	return n - len(p.counters)
+}
+
+// calendarWindow is a window of CalendarQuota.
+type calendarWindow struct {
+	name       string
+	limit      uint16
+	used       uint16
+	start, end time.Time
+}
+
+// calendarWindows nowを含む月・日・時の期間を返します
+func calendarWindows(m Managed, used Count, now time.Time) []calendarWindow {
+	resets := CalendarQuota{}.Resets(m, now)
+	return []calendarWindow{
+		{WindowMonthly, m.Limit.Monthly, used.Monthly, time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()), resets.Monthly},
+		{WindowDaily, m.Limit.Daily, used.Daily, time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()), resets.Daily},
+		{WindowHourly, m.Limit.Hourly, used.Hourly, time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, now.Location()), resets.Hourly},
+	}
+}
+
+// QuotaLimiter enforces Subscribe limits through RateLimiter.
+// カウンタはカレンダーの月・日・時の期間のため、StrategyCalendarのチャンネルのみ制限する
+// 他の方式のチャンネルはConsumeでErrQuotaStrategyを返し、Reconcileでは照合しない
+// Subscribeのドキュメントが正とし、ReconcileSubscribesで定期的に照合する
+type QuotaLimiter struct {
+	Limiter RateLimiter
+	// Prefix is a prefix of keys, e.g. "quota"
+	Prefix string
+}
+
+func (p QuotaLimiter) key(accountID, channel string, w calendarWindow) string {
+	return fmt.Sprintf("%s:%s:%s:%s:%s", p.Prefix, accountID, channel, w.name, w.start.UTC().Format("2006010215"))
+}
+
+// Consume 使用回数 + n が全ての期間の使用制限以下の場合に消費し、Subscribeにも記録します
+// 使用制限を超える場合は加算済みの期間を戻し、QuotaErrorを返す。Subscribeの保存は呼び出し側で行う
+func (p QuotaLimiter) Consume(ctx context.Context, s *Subscribe, channel string, n uint16) error {
+	now := s.now()
+	e := s.effective(s.planCatalogs(), now)
+	if !e.HasChannel(channel) {
+		return fmt.Errorf("%w: %s", ErrQuotaChannel, channel)
+	}
+
+	m := e.Channel(channel)
+	if !isCalendar(m) || !isCalendar(s.Channel(channel)) {
+		return fmt.Errorf("%w: %s %s", ErrQuotaStrategy, channel, m.Strategy)
+	}
+
+	taken := []string{}
+	rollback := func() {
+		for _, key := range taken {
+			if err := p.Limiter.Release(ctx, key, int64(n)); err != nil {
+				log.Error().Err(err).Str("function", "QuotaLimiter.Consume").Msgf("error releasing: %s", key)
+			}
+		}
+	}
+
+	for _, w := range calendarWindows(m, Count{}, now) {
+		key := p.key(s.ID, channel, w)
+		_, ok, err := p.Limiter.Take(ctx, key, int64(n), int64(w.limit), w.end)
+		if err != nil {
+			rollback()
+			return err
+		}
+		if !ok {
+			rollback()
+			return &QuotaError{Status: s.Status(channel)}
+		}
+		taken = append(taken, key)
+	}
+
+	cur := s.Channel(channel)
+	CalendarQuota{}.Consume(&cur, n, now)
+	s.setChannel(channel, cur)
+	return nil
+}
+
+// isCalendar カウンタの期間と一致する方式か
+func isCalendar(m Managed) bool {
+	_, ok := QuotaStrategyOf(m.Strategy).(CalendarQuota)
+	return ok
+}
+
+// Reconcile Subscribeとカウンタの使用回数を照合し、多い方に揃えます
+// カウンタの消失(再起動、退避)と、保存前のSubscribeの更新の両方に対応する。Subscribeを更新した場合はtrue
+// StrategyCalendar以外のチャンネルは、記録(Log, Tokens等)を壊さないよう変更しない
+func (p QuotaLimiter) Reconcile(ctx context.Context, s *Subscribe, now time.Time) (bool, error) {
+	names := []string{ChannelGUI, ChannelAPI}
+	for name := range s.Channels {
+		if name != ChannelGUI && name != ChannelAPI {
+			names = append(names, name)
+		}
+	}
+
+	changed := false
+	for _, name := range names {
+		m := s.Channel(name)
+		if !isCalendar(m) {
+			continue
+		}
+		used := CalendarQuota{}.Usage(m, now)
+		windows := calendarWindows(m, used, now)
+
+		counts := make([]uint16, len(windows))
+		for i, w := range windows {
+			key := p.key(s.ID, name, w)
+			count, err := p.Limiter.Get(ctx, key)
+			if err != nil {
+				return changed, err
+			}
+			switch {
+			case count < int64(w.used):
+				if err := p.Limiter.Set(ctx, key, int64(w.used), w.end); err != nil {
+					return changed, err
+				}
+				counts[i] = w.used
+			default:
+				counts[i] = clampCount(float64(count))
+			}
+		}
+
+		next := Count{Monthly: counts[0], Daily: counts[1], Hourly: counts[2]}
+		if next != used {
+			m.Used = next
+			m.LastUsedAt = now
+			s.setChannel(name, m)
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// ReconcileSubscribes 全ての購読を照合し、更新したSubscribeを保存します
+// 照合中の使用回数の記録を上書きしないよう、1件ずつUpdateDocumentで読み直して照合する
+// 保存に失敗した購読はログを出力して続行する
+func ReconcileSubscribes(ctx context.Context, store Store, colName string, limiter QuotaLimiter, now time.Time) (updated int, err error) {
+	err = Each(ctx, store, colName, nil, func(v Subscribe) error {
+		var cur Subscribe
+		var limiterErr error
+		err := UpdateDocument(ctx, store, colName, v.ID, &cur, func(exists bool) error {
+			if !exists {
+				return fmt.Errorf("%w: %s/%s", ErrNotFound, colName, v.ID)
+			}
+			changed, err := limiter.Reconcile(ctx, &cur, now)
+			if err != nil {
+				limiterErr = err
+				return err
+			}
+			if !changed {
+				return errUnchanged
+			}
+			return nil
+		})
+		switch {
+		case limiterErr != nil:
+			return limiterErr
+		case errors.Is(err, errUnchanged):
+			return nil
+		case errors.Is(err, ErrNotFound):
+			// 照合中に削除された
+			return nil
+		case err != nil:
+			log.Error().Err(err).Str("function", "ReconcileSubscribes").Msgf("error reconciling subscribe: %s", v.ID)
+			return nil
+		}
+		updated++
+		return nil
+	})
+	return updated, err
+}
@@ -0,0 +1,397 @@
+package models
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisRateLimiter(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	// PEXPIREATはミリ秒
+	now := time.Now().Truncate(time.Millisecond)
+	mr.SetTime(now)
+
+	p := NewRedisRateLimiter(mr.Addr())
+	defer p.Close()
+
+	tests := []struct {
+		name      string
+		n         int64
+		wantCount int64
+		wantOK    bool
+	}{
+		{name: "first", n: 2, wantCount: 2, wantOK: true},
+		{name: "limit-1 to limit", n: 1, wantCount: 3, wantOK: true},
+		{name: "over limit", n: 1, wantCount: 3, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count, ok, err := p.Take(ctx, "k", tt.n, 3, now.Add(time.Hour))
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantCount, count)
+			assert.Equal(t, tt.wantOK, ok)
+		})
+	}
+
+	count, err := p.Get(ctx, "k")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+	assert.Equal(t, time.Hour, mr.TTL("k"))
+
+	require.NoError(t, p.Release(ctx, "k", 1))
+	count, err = p.Get(ctx, "k")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	// 0以下は削除する
+	require.NoError(t, p.Release(ctx, "k", 5))
+	assert.False(t, mr.Exists("k"))
+	require.NoError(t, p.Release(ctx, "missing", 1))
+	assert.False(t, mr.Exists("missing"))
+
+	require.NoError(t, p.Set(ctx, "s", 7, now.Add(time.Minute)))
+	count, err = p.Get(ctx, "s")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), count)
+	assert.Equal(t, time.Minute, mr.TTL("s"))
+
+	// 期限なし
+	_, ok, err := p.Take(ctx, "noexpire", 1, 1, time.Time{})
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Zero(t, mr.TTL("noexpire"))
+
+	// limit 0
+	count, ok, err = p.Take(ctx, "zero", 1, 0, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Zero(t, count)
+	assert.False(t, mr.Exists("zero"))
+
+	// 期限切れ
+	mr.FastForward(time.Hour)
+	count, err = p.Get(ctx, "missing")
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
+
+func TestRedisRateLimiterConcurrent(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+
+	const limit = 5
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	taken := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// 接続ごとに別のクライアントとする
+			p := NewRedisRateLimiter(mr.Addr())
+			defer p.Close()
+			_, ok, err := p.Take(ctx, "k", 1, limit, time.Now().Add(time.Hour))
+			if !assert.NoError(t, err) {
+				return
+			}
+			if ok {
+				mu.Lock()
+				taken++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, limit, taken)
+	v, err := mr.Get("k")
+	require.NoError(t, err)
+	assert.Equal(t, "5", v)
+}
+
+func TestRedisRateLimiterPool(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+
+	p := NewRedisRateLimiter(mr.Addr())
+	p.PoolSize = 2
+	defer p.Close()
+
+	const limit = 5
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	taken := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, ok, err := p.Take(ctx, "k", 1, limit, time.Now().Add(time.Hour))
+			if !assert.NoError(t, err) {
+				return
+			}
+			if ok {
+				mu.Lock()
+				taken++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, limit, taken)
+	assert.LessOrEqual(t, mr.TotalConnectionCount(), 2)
+}
+
+func TestRedisRateLimiterAuth(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	mr.RequireAuth("secret")
+
+	p := NewRedisRateLimiter(mr.Addr())
+	defer p.Close()
+	_, _, err := p.Take(ctx, "k", 1, 1, time.Time{})
+	assert.ErrorIs(t, err, ErrRedis)
+
+	p = NewRedisRateLimiter(mr.Addr())
+	p.Password = "secret"
+	p.DB = 2
+	defer p.Close()
+	_, ok, err := p.Take(ctx, "k", 1, 1, time.Time{})
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, mr.DB(2).Exists("k"))
+}
+
+func TestRedisRateLimiterReconnect(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+
+	p := NewRedisRateLimiter(mr.Addr())
+	p.Timeout = time.Second
+	defer p.Close()
+	_, _, err := p.Take(ctx, "k", 1, 3, time.Time{})
+	require.NoError(t, err)
+
+	mr.Close()
+	_, _, err = p.Take(ctx, "k", 1, 3, time.Time{})
+	assert.Error(t, err)
+
+	require.NoError(t, mr.Restart())
+	count, ok, err := p.Take(ctx, "k", 1, 3, time.Time{})
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), count)
+}
+
+func TestMemoryRateLimiter(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFixedClock(time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC))
+	p := NewMemoryRateLimiter()
+	p.Clock = clock
+
+	tests := []struct {
+		name      string
+		n         int64
+		limit     int64
+		wantCount int64
+		wantOK    bool
+	}{
+		{name: "limit 0", n: 1, limit: 0, wantCount: 0, wantOK: false},
+		{name: "first", n: 2, limit: 3, wantCount: 2, wantOK: true},
+		{name: "limit-1, n 2", n: 2, limit: 3, wantCount: 2, wantOK: false},
+		{name: "limit-1, n 1", n: 1, limit: 3, wantCount: 3, wantOK: true},
+		{name: "over limit", n: 1, limit: 3, wantCount: 3, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count, ok, err := p.Take(ctx, "k", tt.n, tt.limit, clock.Now().Add(time.Hour))
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantCount, count)
+			assert.Equal(t, tt.wantOK, ok)
+		})
+	}
+
+	require.NoError(t, p.Release(ctx, "k", 5))
+	count, err := p.Get(ctx, "k")
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	require.NoError(t, p.Set(ctx, "k", 2, clock.Now().Add(time.Minute)))
+	require.NoError(t, p.Set(ctx, "other", 1, clock.Now().Add(time.Hour)))
+	count, err = p.Get(ctx, "k")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	clock.Add(time.Minute)
+	count, err = p.Get(ctx, "k")
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	require.NoError(t, p.Set(ctx, "k", 2, clock.Now().Add(time.Minute)))
+	clock.Add(time.Minute)
+	assert.Equal(t, 1, p.Sweep())
+	count, err = p.Get(ctx, "other")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestFallbackRateLimiter(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+
+	primary := NewRedisRateLimiter(mr.Addr())
+	primary.Timeout = time.Second
+	defer primary.Close()
+	p := NewFallbackRateLimiter(primary)
+	expireAt := time.Now().Add(time.Hour)
+
+	_, ok, err := p.Take(ctx, "k", 2, 3, expireAt)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	count, err := p.Secondary.Get(ctx, "k")
+	require.NoError(t, err)
+	assert.Zero(t, count, "secondary is not used while primary works")
+
+	// 障害中はSecondaryで制限する
+	mr.Close()
+	count, ok, err = p.Take(ctx, "k", 3, 3, expireAt)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), count)
+	_, ok, err = p.Take(ctx, "k", 1, 3, expireAt)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	count, err = p.Get(ctx, "k")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	require.NoError(t, p.Release(ctx, "k", 1))
+	count, err = p.Get(ctx, "k")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	// Setは障害中もSecondaryに反映する
+	require.NoError(t, p.Set(ctx, "s", 4, expireAt))
+	count, err = p.Secondary.Get(ctx, "s")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), count)
+
+	// 復旧後はPrimary
+	require.NoError(t, mr.Restart())
+	count, err = p.Get(ctx, "k")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+	require.NoError(t, p.Set(ctx, "s", 5, expireAt))
+	v, err := mr.Get("s")
+	require.NoError(t, err)
+	assert.Equal(t, "5", v)
+}
+
+func TestQuotaLimiterConsume(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFixedClock(time.Date(2026, 12, 31, 23, 59, 0, 0, time.UTC))
+	memory := NewMemoryRateLimiter()
+	memory.Clock = clock
+	limiter := QuotaLimiter{Limiter: memory, Prefix: "quota"}
+
+	s := newQuotaSubscribe(StrategyCalendar, Count{Monthly: 100, Daily: 10, Hourly: 3}, clock)
+	s.ID = "acct"
+
+	require.NoError(t, limiter.Consume(ctx, s, ChannelAPI, 2))
+	err := limiter.Consume(ctx, s, ChannelAPI, 2)
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	require.NoError(t, limiter.Consume(ctx, s, ChannelAPI, 1))
+	assert.Equal(t, Count{Monthly: 3, Daily: 3, Hourly: 3}, s.Channel(ChannelAPI).Used)
+
+	// 拒否された分は戻す
+	month := calendarWindows(s.Channel(ChannelAPI), Count{}, clock.Now())[0]
+	count, err := memory.Get(ctx, limiter.key(s.ID, ChannelAPI, month))
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	// 年をまたぐと新しい期間のカウンタ
+	clock.Add(time.Minute)
+	require.NoError(t, limiter.Consume(ctx, s, ChannelAPI, 3))
+	assert.Equal(t, Count{Monthly: 3, Daily: 3, Hourly: 3}, s.Channel(ChannelAPI).Used)
+
+	for _, strategy := range []string{StrategySlidingLog, StrategySlidingCounter, StrategyTokenBucket} {
+		t.Run(strategy, func(t *testing.T) {
+			s := newQuotaSubscribe(strategy, Count{Monthly: 100, Daily: 10, Hourly: 3}, clock)
+			s.ID = "acct-" + strategy
+			err := limiter.Consume(ctx, s, ChannelAPI, 1)
+			assert.ErrorIs(t, err, ErrQuotaStrategy)
+			assert.Empty(t, s.Channel(ChannelAPI).Log)
+		})
+	}
+}
+
+func TestReconcileSubscribes(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 3, 10, 12, 30, 0, 0, time.UTC)
+	clock := NewFixedClock(now)
+	memory := NewMemoryRateLimiter()
+	memory.Clock = clock
+	limiter := QuotaLimiter{Limiter: memory, Prefix: "quota"}
+	store := NewMemoryStore()
+
+	limit := Count{Monthly: 100, Daily: 10, Hourly: 5}
+
+	// カウンタの方が多い
+	behind := newQuotaSubscribe(StrategyCalendar, limit, clock)
+	behind.ID = "behind"
+	m := behind.Channel(ChannelAPI)
+	CalendarQuota{}.Consume(&m, 1, now)
+	behind.setChannel(ChannelAPI, m)
+	for _, w := range calendarWindows(m, Count{}, now) {
+		require.NoError(t, memory.Set(ctx, limiter.key(behind.ID, ChannelAPI, w), 4, w.end))
+	}
+
+	// Subscribeの方が多い
+	ahead := newQuotaSubscribe(StrategyCalendar, limit, clock)
+	ahead.ID = "ahead"
+	m = ahead.Channel(ChannelAPI)
+	CalendarQuota{}.Consume(&m, 2, now)
+	ahead.setChannel(ChannelAPI, m)
+
+	// カレンダー以外は照合しない
+	log := newQuotaSubscribe(StrategySlidingLog, limit, clock)
+	log.ID = "log"
+	m = log.Channel(ChannelAPI)
+	SlidingLogQuota{}.Consume(&m, 1, now.Add(-time.Hour))
+	log.setChannel(ChannelAPI, m)
+	for _, w := range calendarWindows(m, Count{}, now) {
+		require.NoError(t, memory.Set(ctx, limiter.key(log.ID, ChannelAPI, w), 4, w.end))
+	}
+
+	for _, v := range []*Subscribe{behind, ahead, log} {
+		require.NoError(t, store.Set(ctx, "subscribes", v.ID, *v))
+	}
+
+	updated, err := ReconcileSubscribes(ctx, store, "subscribes", limiter, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, updated)
+
+	var got Subscribe
+	require.NoError(t, store.Get(ctx, "subscribes", "behind", &got))
+	assert.Equal(t, Count{Monthly: 4, Daily: 4, Hourly: 4}, got.Channel(ChannelAPI).Used)
+
+	require.NoError(t, store.Get(ctx, "subscribes", "ahead", &got))
+	assert.Equal(t, Count{Monthly: 2, Daily: 2, Hourly: 2}, got.Channel(ChannelAPI).Used)
+	for _, w := range calendarWindows(got.Channel(ChannelAPI), Count{}, now) {
+		count, err := memory.Get(ctx, limiter.key("ahead", ChannelAPI, w))
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), count, w.name)
+	}
+
+	require.NoError(t, store.Get(ctx, "subscribes", "log", &got))
+	assert.Equal(t, log.Channel(ChannelAPI), got.Channel(ChannelAPI))
+	assert.Len(t, got.Channel(ChannelAPI).Log, 1)
+}
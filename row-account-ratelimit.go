@@ -0,0 +1,363 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var ErrXRateLimited = errors.New("x api rate limited")
+
+// X API endpoints tracked by XRateLimit.
+const (
+	XEndpointPostTweet   = "POST /2/tweets"
+	XEndpointDeleteTweet = "DELETE /2/tweets/:id"
+	XEndpointMediaUpload = "POST /1.1/media/upload.json"
+)
+
+// XRateLimitFallback is how long to wait after 429 without reset headers.
+// リセット時刻が不明な場合、次の期間のリセット時刻としても使用する
+var XRateLimitFallback = 15 * time.Minute
+
+// XUserLimitWindow is the window of x-user-limit-24hour-*.
+const XUserLimitWindow = 24 * time.Hour
+
+// XRateLimit is the last known X API rate limit of an account and an endpoint.
+// アプリ単位の使用制限(x-rate-limit-*)と、ユーザー単位の24時間の使用制限(x-user-limit-24hour-*)を保持する
+// Limitが0の場合はヘッダ未取得として扱い、制限しない
+type XRateLimit struct {
+	// AccountID is Twitter/X AccountID, Account.ID
+	AccountID string    `firestore:"account_id" json:"account_id"`
+	Endpoint  string    `firestore:"endpoint" json:"endpoint"`
+	Limit     int       `firestore:"limit" json:"limit"`
+	Remaining int       `firestore:"remaining" json:"remaining"`
+	Reset     time.Time `firestore:"reset,omitempty" json:"reset,omitempty"`
+
+	UserLimit     int       `firestore:"user_limit,omitempty" json:"user_limit,omitempty"`
+	UserRemaining int       `firestore:"user_remaining,omitempty" json:"user_remaining,omitempty"`
+	UserReset     time.Time `firestore:"user_reset,omitempty" json:"user_reset,omitempty"`
+
+	// LimitedAt is when 429 was returned, zero if not limited.
+	LimitedAt time.Time `firestore:"limited_at,omitempty" json:"limited_at,omitempty"`
+	UpdatedAt time.Time `firestore:"updated_at" json:"updated_at"`
+}
+
+// headerInt ヘッダの整数値を返します、無い場合はfalse
+func headerInt(h http.Header, key string) (int, bool) {
+	v := strings.TrimSpace(h.Get(key))
+	if v == "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// ParseXRateLimit X APIのレスポンスから使用制限を読み込みます
+// 429でリセット時刻が無い場合は、Retry-After、それも無い場合はXRateLimitFallback後をリセット時刻とする
+func ParseXRateLimit(accountID, endpoint string, status int, h http.Header, at time.Time) XRateLimit {
+	p := XRateLimit{
+		AccountID: accountID,
+		Endpoint:  endpoint,
+		UpdatedAt: at,
+	}
+
+	if n, ok := headerInt(h, "x-rate-limit-limit"); ok {
+		p.Limit = n
+	}
+	if n, ok := headerInt(h, "x-rate-limit-remaining"); ok {
+		p.Remaining = n
+	}
+	if n, ok := headerInt(h, "x-rate-limit-reset"); ok {
+		p.Reset = time.Unix(int64(n), 0)
+	}
+	if n, ok := headerInt(h, "x-user-limit-24hour-limit"); ok {
+		p.UserLimit = n
+	}
+	if n, ok := headerInt(h, "x-user-limit-24hour-remaining"); ok {
+		p.UserRemaining = n
+	}
+	if n, ok := headerInt(h, "x-user-limit-24hour-reset"); ok {
+		p.UserReset = time.Unix(int64(n), 0)
+	}
+
+	if status != http.StatusTooManyRequests {
+		return p
+	}
+
+	p.LimitedAt = at
+	// どちらの制限か判別できないため、残り回数が0の方、または両方を制限中とする
+	switch {
+	case p.UserLimit > 0 && p.UserRemaining == 0 && p.UserReset.After(at):
+	case p.Limit > 0 && p.Remaining == 0 && p.Reset.After(at):
+	default:
+		reset := at.Add(XRateLimitFallback)
+		if sec, ok := headerInt(h, "Retry-After"); ok {
+			reset = at.Add(time.Duration(sec) * time.Second)
+		}
+		p.Limit = max(p.Limit, 1)
+		p.Remaining = 0
+		p.Reset = reset
+	}
+	return p
+}
+
+// resetAt リセット時刻を返します、ヘッダに無い場合は記録からXRateLimitFallback後
+func (p XRateLimit) resetAt() time.Time {
+	if p.Reset.IsZero() && !p.UpdatedAt.IsZero() {
+		return p.UpdatedAt.Add(XRateLimitFallback)
+	}
+	return p.Reset
+}
+
+// userResetAt ユーザー単位のリセット時刻を返します、ヘッダに無い場合は記録からXUserLimitWindow後
+func (p XRateLimit) userResetAt() time.Time {
+	if p.UserReset.IsZero() && !p.UpdatedAt.IsZero() {
+		return p.UpdatedAt.Add(XUserLimitWindow)
+	}
+	return p.UserReset
+}
+
+// nextWindow resetを過ぎている場合、nowより後の次の期間のリセット時刻を返します
+func nextWindow(reset time.Time, window time.Duration, now time.Time) time.Time {
+	if reset.IsZero() {
+		return now.Add(window)
+	}
+	if reset.After(now) {
+		return reset
+	}
+	return reset.Add((now.Sub(reset)/window + 1) * window)
+}
+
+// Available 指定日時に投稿可能な回数を返します、-1は制限なし(ヘッダ未取得)
+// リセット時刻を過ぎた制限は回復したものとする
+func (p XRateLimit) Available(now time.Time) int {
+	n := -1
+	if p.Limit > 0 {
+		n = p.Limit
+		if now.Before(p.resetAt()) {
+			n = p.Remaining
+		}
+	}
+	if p.UserLimit > 0 {
+		user := p.UserLimit
+		if now.Before(p.userResetAt()) {
+			user = p.UserRemaining
+		}
+		if n < 0 || user < n {
+			n = user
+		}
+	}
+	return n
+}
+
+// NextAvailable 次に投稿可能な日時を返します、投稿可能な場合はnow
+// 両方の制限に達している場合は遅い方のリセット時刻
+func (p XRateLimit) NextAvailable(now time.Time) time.Time {
+	next := now
+	if reset := p.resetAt(); p.Limit > 0 && p.Remaining <= 0 && reset.After(next) {
+		next = reset
+	}
+	if reset := p.userResetAt(); p.UserLimit > 0 && p.UserRemaining <= 0 && reset.After(next) {
+		next = reset
+	}
+	return next
+}
+
+// Take 投稿した回数を予測として残り回数から減らします
+// リセット時刻を過ぎている場合は回復し、次の期間のリセット時刻を予測する。次のレスポンスのヘッダで上書きされる
+func (p *XRateLimit) Take(n int, now time.Time) {
+	if p.Limit > 0 {
+		reset := p.resetAt()
+		if !now.Before(reset) {
+			// アプリ単位の期間の長さはヘッダに無いため、XRateLimitFallback後とする
+			p.Remaining = p.Limit
+			reset = now.Add(XRateLimitFallback)
+		}
+		p.Reset = reset
+		p.Remaining = max(0, p.Remaining-n)
+	}
+	if p.UserLimit > 0 {
+		reset := p.userResetAt()
+		if !now.Before(reset) {
+			p.UserRemaining = p.UserLimit
+			reset = nextWindow(reset, XUserLimitWindow, now)
+		}
+		p.UserReset = reset
+		p.UserRemaining = max(0, p.UserRemaining-n)
+	}
+	p.UpdatedAt = now
+}
+
+// Err 制限中の場合はErrXRateLimitedを返します
+func (p XRateLimit) Err(now time.Time) error {
+	if next := p.NextAvailable(now); next.After(now) {
+		return fmt.Errorf("%w: %s %s, available at %s", ErrXRateLimited, p.AccountID, p.Endpoint, next.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// XRateLimitStore persists XRateLimit per account and endpoint.
+type XRateLimitStore struct {
+	Store      Store
+	Collection string
+}
+
+// xRateLimitKey e.g. {account}_POST_2_tweets
+func xRateLimitKey(accountID, endpoint string) string {
+	fields := strings.FieldsFunc(endpoint, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return accountID + "_" + strings.Join(fields, "_")
+}
+
+// Record レスポンスの使用制限を保存します
+// x-user-limit-24hour-*のヘッダが無いレスポンスは、記録済みのユーザー単位の制限を保持する
+func (p XRateLimitStore) Record(ctx context.Context, limit XRateLimit) error {
+	var cur XRateLimit
+	return UpdateDocument(ctx, p.Store, p.Collection, xRateLimitKey(limit.AccountID, limit.Endpoint), &cur, func(exists bool) error {
+		next := limit
+		if exists && next.UserLimit == 0 && cur.UserLimit > 0 {
+			next.UserLimit = cur.UserLimit
+			next.UserRemaining = cur.UserRemaining
+			// 記録からの予測はUpdatedAtが変わるため、リセット時刻を確定しておく
+			next.UserReset = cur.userResetAt()
+		}
+		cur = next
+		return nil
+	})
+}
+
+// Get 使用制限を返します、未記録の場合は制限なしのXRateLimit
+func (p XRateLimitStore) Get(ctx context.Context, accountID, endpoint string) (XRateLimit, error) {
+	var v XRateLimit
+	if err := p.Store.Get(ctx, p.Collection, xRateLimitKey(accountID, endpoint), &v); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return XRateLimit{AccountID: accountID, Endpoint: endpoint}, nil
+		}
+		return XRateLimit{}, err
+	}
+	return v, nil
+}
+
+// DeferredPost is a post postponed by XRateLimit.
+type DeferredPost struct {
+	Post Post
+	// At is the next available slot.
+	At time.Time
+}
+
+// postable 承認済み、予約済みで削除されていないか
+// Statusが未設定の旧データはCheckedで判定する、投稿済み(LastPostedAt)でも繰り返し投稿の対象とする
+func postable(p Post) bool {
+	if p.IsDeleted() {
+		return false
+	}
+	if p.Status == StatusUnset {
+		return p.Checked > 0
+	}
+	s := p.CurrentStatus()
+	return s == StatusApproved || s == StatusScheduled
+}
+
+// SelectPosts 投稿可能なPostを、制限の残り回数分だけ選択します
+// 承認済み、予約済みのPostをPriorityの高い順、LastPostedAtの古い順に並べ、残りは次に投稿可能な日時まで延期する
+func SelectPosts(posts []Post, limit XRateLimit, now time.Time) (ready []Post, deferred []DeferredPost) {
+	candidates := []Post{}
+	for _, v := range posts {
+		if postable(v) {
+			candidates = append(candidates, v)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Priority != candidates[j].Priority {
+			return candidates[i].Priority > candidates[j].Priority
+		}
+		return candidates[i].LastPostedAt.Before(candidates[j].LastPostedAt)
+	})
+
+	n := limit.Available(now)
+	if n < 0 || n > len(candidates) {
+		n = len(candidates)
+	}
+	ready = candidates[:n]
+
+	if n < len(candidates) {
+		// 残り回数を使い切った後の日時
+		next := limit
+		next.Take(n, now)
+		at := next.NextAvailable(now)
+		for _, v := range candidates[n:] {
+			deferred = append(deferred, DeferredPost{Post: v, At: at})
+		}
+	}
+	return ready, deferred
+}
+
+// IsDue 予定日時、または延期した日時を過ぎている場合に投稿するかを返します
+// 制限中の場合はfalseと次に投稿可能な日時を返し、DeferredUntilに記録する。呼び出し側でScheduleを保存すること
+// Dailyの予定時刻は1分間のため、延期しないと次の予定時刻まで投稿されない
+func (s *Schedule) IsDue(t time.Time, limit XRateLimit) (bool, time.Time) {
+	deferred := !s.DeferredUntil.IsZero() && !t.Before(s.DeferredUntil)
+	if !deferred && !s.IsImmediate && !s.IsScheduleToday(t) {
+		return false, time.Time{}
+	}
+	if next := limit.NextAvailable(t); next.After(t) {
+		s.DeferredUntil = next
+		return false, next
+	}
+	s.DeferredUntil = time.Time{}
+	return true, t
+}
+
+// SelectDuePosts アカウントの投稿するPostを選択します
+// XEndpointPostTweetの使用制限を読み込み、予定日時(IsDue)のPostと予約の無いPostからSelectPostsで選択する
+// 制限で延期したPostのScheduleはDeferredUntilを更新する、呼び出し側でschedulesを保存すること
+func (p XRateLimitStore) SelectDuePosts(ctx context.Context, accountID string, posts []Post, schedules []Schedule, now time.Time) (ready []Post, deferred []DeferredPost, err error) {
+	limit, err := p.Get(ctx, accountID, XEndpointPostTweet)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byPost := make(map[string]int, len(schedules))
+	for i, v := range schedules {
+		byPost[v.PostID] = i
+	}
+
+	candidates := []Post{}
+	for _, v := range posts {
+		if !postable(v) {
+			continue
+		}
+		i, ok := byPost[v.UUID]
+		if !ok {
+			if !v.IsSchedule {
+				candidates = append(candidates, v)
+			}
+			continue
+		}
+		due, next := schedules[i].IsDue(now, limit)
+		switch {
+		case due:
+			candidates = append(candidates, v)
+		case !next.IsZero():
+			deferred = append(deferred, DeferredPost{Post: v, At: next})
+		}
+	}
+
+	ready, rest := SelectPosts(candidates, limit, now)
+	for _, v := range rest {
+		if i, ok := byPost[v.Post.UUID]; ok {
+			schedules[i].DeferredUntil = v.At
+		}
+	}
+	return ready, append(deferred, rest...), nil
+}
@@ -0,0 +1,375 @@
+package models
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func xHeader(kv ...string) http.Header {
+	h := http.Header{}
+	for i := 0; i+1 < len(kv); i += 2 {
+		h.Set(kv[i], kv[i+1])
+	}
+	return h
+}
+
+func TestParseXRateLimit(t *testing.T) {
+	at := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	reset := at.Add(10 * time.Minute).Truncate(time.Second)
+	unix := strconv.FormatInt(reset.Unix(), 10)
+
+	tests := []struct {
+		name      string
+		status    int
+		header    http.Header
+		wantReset time.Time
+		wantNext  time.Time
+	}{
+		{
+			name:      "ok",
+			status:    http.StatusOK,
+			header:    xHeader("x-rate-limit-limit", "50", "x-rate-limit-remaining", "10", "x-rate-limit-reset", unix),
+			wantReset: reset,
+			wantNext:  at,
+		},
+		{
+			name:      "exhausted",
+			status:    http.StatusTooManyRequests,
+			header:    xHeader("x-rate-limit-limit", "50", "x-rate-limit-remaining", "0", "x-rate-limit-reset", unix),
+			wantReset: reset,
+			wantNext:  reset,
+		},
+		{
+			name:      "user limit exhausted",
+			status:    http.StatusTooManyRequests,
+			header:    xHeader("x-rate-limit-limit", "50", "x-rate-limit-remaining", "10", "x-rate-limit-reset", unix, "x-user-limit-24hour-limit", "17", "x-user-limit-24hour-remaining", "0", "x-user-limit-24hour-reset", unix),
+			wantReset: reset,
+			wantNext:  reset,
+		},
+		{
+			name:      "retry after",
+			status:    http.StatusTooManyRequests,
+			header:    xHeader("Retry-After", "120"),
+			wantReset: at.Add(2 * time.Minute),
+			wantNext:  at.Add(2 * time.Minute),
+		},
+		{
+			name:      "no headers",
+			status:    http.StatusTooManyRequests,
+			header:    http.Header{},
+			wantReset: at.Add(XRateLimitFallback),
+			wantNext:  at.Add(XRateLimitFallback),
+		},
+		{
+			name:      "stale reset",
+			status:    http.StatusTooManyRequests,
+			header:    xHeader("x-rate-limit-limit", "50", "x-rate-limit-remaining", "0", "x-rate-limit-reset", strconv.FormatInt(at.Add(-time.Minute).Unix(), 10)),
+			wantReset: at.Add(XRateLimitFallback),
+			wantNext:  at.Add(XRateLimitFallback),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseXRateLimit("acct", XEndpointPostTweet, tt.status, tt.header, at)
+			assert.True(t, tt.wantReset.Equal(got.Reset), "reset: %s", got.Reset)
+			assert.True(t, tt.wantNext.Equal(got.NextAvailable(at)), "next: %s", got.NextAvailable(at))
+			if tt.wantNext.After(at) {
+				assert.ErrorIs(t, got.Err(at), ErrXRateLimited)
+			} else {
+				assert.NoError(t, got.Err(at))
+			}
+		})
+	}
+}
+
+func TestXRateLimitTake(t *testing.T) {
+	now := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		limit         XRateLimit
+		n             int
+		wantAvailable int
+		wantNext      time.Time
+	}{
+		{
+			name:          "unlimited",
+			limit:         XRateLimit{},
+			n:             5,
+			wantAvailable: -1,
+			wantNext:      now,
+		},
+		{
+			name:          "remaining",
+			limit:         XRateLimit{Limit: 5, Remaining: 3, Reset: now.Add(time.Minute), UpdatedAt: now},
+			n:             2,
+			wantAvailable: 3,
+			wantNext:      now,
+		},
+		{
+			name:          "exhausted",
+			limit:         XRateLimit{Limit: 5, Remaining: 1, Reset: now.Add(time.Minute), UpdatedAt: now},
+			n:             1,
+			wantAvailable: 1,
+			wantNext:      now.Add(time.Minute),
+		},
+		{
+			name:          "stale reset, exhausted",
+			limit:         XRateLimit{Limit: 5, Remaining: 0, Reset: now.Add(-time.Hour), UpdatedAt: now.Add(-2 * time.Hour)},
+			n:             5,
+			wantAvailable: 5,
+			wantNext:      now.Add(XRateLimitFallback),
+		},
+		{
+			name:          "unknown reset, exhausted",
+			limit:         XRateLimit{Limit: 5, Remaining: 0, UpdatedAt: now.Add(-time.Minute)},
+			n:             0,
+			wantAvailable: 0,
+			wantNext:      now.Add(-time.Minute).Add(XRateLimitFallback),
+		},
+		{
+			name:          "stale user reset, exhausted",
+			limit:         XRateLimit{UserLimit: 17, UserRemaining: 0, UserReset: now.Add(-time.Hour), UpdatedAt: now.Add(-25 * time.Hour)},
+			n:             17,
+			wantAvailable: 17,
+			wantNext:      now.Add(-time.Hour).Add(XUserLimitWindow),
+		},
+		{
+			name:          "unknown user reset",
+			limit:         XRateLimit{UserLimit: 17, UserRemaining: 1, UpdatedAt: now.Add(-time.Hour)},
+			n:             1,
+			wantAvailable: 1,
+			wantNext:      now.Add(-time.Hour).Add(XUserLimitWindow),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantAvailable, tt.limit.Available(now))
+
+			next := tt.limit
+			next.Take(tt.n, now)
+			got := next.NextAvailable(now)
+			assert.True(t, tt.wantNext.Equal(got), "next: %s", got)
+		})
+	}
+}
+
+func TestSelectPosts(t *testing.T) {
+	now := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	posts := []Post{
+		{UUID: "low", Checked: 1, Priority: 1},
+		{UUID: "high", Checked: 1, Priority: 5},
+		{UUID: "old", Checked: 1, Priority: 1, LastPostedAt: now.Add(-time.Hour)},
+		{UUID: "draft", Priority: 9},
+		{UUID: "deleted", Checked: 1, Priority: 9, IsDelete: true},
+		{UUID: "scheduled", Checked: 1, IsSchedule: true},
+	}
+
+	tests := []struct {
+		name         string
+		limit        XRateLimit
+		wantReady    []string
+		wantDeferred []string
+		wantAt       time.Time
+	}{
+		{
+			name:      "unlimited",
+			limit:     XRateLimit{},
+			wantReady: []string{"high", "low", "old", "scheduled"},
+		},
+		{
+			name:         "remaining",
+			limit:        XRateLimit{Limit: 50, Remaining: 2, Reset: now.Add(10 * time.Minute), UpdatedAt: now},
+			wantReady:    []string{"high", "low"},
+			wantDeferred: []string{"old", "scheduled"},
+			wantAt:       now.Add(10 * time.Minute),
+		},
+		{
+			name:         "exhausted",
+			limit:        XRateLimit{Limit: 50, Remaining: 0, Reset: now.Add(10 * time.Minute), UpdatedAt: now},
+			wantReady:    []string{},
+			wantDeferred: []string{"high", "low", "old", "scheduled"},
+			wantAt:       now.Add(10 * time.Minute),
+		},
+		{
+			// リセット時刻を過ぎて回復した分を使い切った後は、次の期間まで延期する
+			name:         "stale reset",
+			limit:        XRateLimit{Limit: 2, Remaining: 0, Reset: now.Add(-time.Hour), UpdatedAt: now.Add(-2 * time.Hour)},
+			wantReady:    []string{"high", "low"},
+			wantDeferred: []string{"old", "scheduled"},
+			wantAt:       now.Add(XRateLimitFallback),
+		},
+		{
+			name:         "unknown reset",
+			limit:        XRateLimit{Limit: 2, Remaining: 0, UpdatedAt: now.Add(-time.Minute)},
+			wantReady:    []string{},
+			wantDeferred: []string{"high", "low", "old", "scheduled"},
+			wantAt:       now.Add(-time.Minute).Add(XRateLimitFallback),
+		},
+		{
+			name:         "user limit",
+			limit:        XRateLimit{Limit: 50, Remaining: 50, Reset: now.Add(10 * time.Minute), UserLimit: 17, UserRemaining: 1, UserReset: now.Add(3 * time.Hour), UpdatedAt: now},
+			wantReady:    []string{"high"},
+			wantDeferred: []string{"low", "old", "scheduled"},
+			wantAt:       now.Add(3 * time.Hour),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ready, deferred := SelectPosts(posts, tt.limit, now)
+
+			got := []string{}
+			for _, v := range ready {
+				got = append(got, v.UUID)
+			}
+			assert.Equal(t, tt.wantReady, got)
+
+			got = []string{}
+			for _, v := range deferred {
+				got = append(got, v.Post.UUID)
+				assert.True(t, tt.wantAt.Equal(v.At), "%s at %s", v.Post.UUID, v.At)
+				assert.True(t, v.At.After(now), "%s is deferred to now", v.Post.UUID)
+			}
+			assert.ElementsMatch(t, tt.wantDeferred, got)
+		})
+	}
+}
+
+func TestSelectPostsOrder(t *testing.T) {
+	now := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	posts := []Post{
+		{UUID: "recent", Checked: 1, LastPostedAt: now.Add(-time.Minute)},
+		{UUID: "high", Checked: 1, Priority: 5},
+		{UUID: "old", Checked: 1, LastPostedAt: now.Add(-time.Hour)},
+	}
+	ready, deferred := SelectPosts(posts, XRateLimit{}, now)
+	assert.Empty(t, deferred)
+	require.Len(t, ready, 3)
+	assert.Equal(t, []string{"high", "old", "recent"}, []string{ready[0].UUID, ready[1].UUID, ready[2].UUID})
+}
+
+func TestScheduleIsDue(t *testing.T) {
+	slot := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	reset := slot.Add(10 * time.Minute)
+	limited := XRateLimit{Limit: 50, Remaining: 0, Reset: reset, UpdatedAt: slot}
+
+	s := Schedule{PostID: "p", TypeSchedule: Daily, Times: []time.Time{slot}}
+
+	tests := []struct {
+		name         string
+		at           time.Time
+		limit        XRateLimit
+		wantDue      bool
+		wantNext     time.Time
+		wantDeferred time.Time
+	}{
+		{name: "before slot", at: slot.Add(-time.Minute), limit: limited},
+		{name: "slot, limited", at: slot, limit: limited, wantNext: reset, wantDeferred: reset},
+		{name: "after slot, before deferral", at: slot.Add(5 * time.Minute), limit: limited, wantDeferred: reset},
+		// Dailyの予定時刻を過ぎていても、延期した日時に投稿する
+		{name: "deferral", at: reset, limit: limited, wantDue: true, wantNext: reset},
+		{name: "posted", at: reset.Add(time.Minute)},
+		{name: "next slot", at: slot.Add(24 * time.Hour), wantDue: true, wantNext: slot.Add(24 * time.Hour)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			due, next := s.IsDue(tt.at, tt.limit)
+			assert.Equal(t, tt.wantDue, due)
+			assert.True(t, tt.wantNext.Equal(next), "next: %s", next)
+			assert.True(t, tt.wantDeferred.Equal(s.DeferredUntil), "deferred until: %s", s.DeferredUntil)
+		})
+	}
+
+	immediate := Schedule{IsImmediate: true}
+	due, next := immediate.IsDue(slot, XRateLimit{})
+	assert.True(t, due)
+	assert.Equal(t, slot, next)
+}
+
+func TestSelectDuePosts(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	limits := XRateLimitStore{Store: NewMemoryStore(), Collection: "x_rate_limits"}
+	require.NoError(t, limits.Record(ctx, XRateLimit{
+		AccountID: "acct", Endpoint: XEndpointPostTweet,
+		Limit: 50, Remaining: 1, Reset: now.Add(10 * time.Minute), UpdatedAt: now,
+	}))
+
+	posts := []Post{
+		{UUID: "due", ID: "acct", Checked: 1, IsSchedule: true, Priority: 5},
+		{UUID: "later", ID: "acct", Checked: 1, IsSchedule: true, Priority: 5},
+		{UUID: "unscheduled", ID: "acct", Checked: 1},
+		{UUID: "no-schedule", ID: "acct", Checked: 1, IsSchedule: true},
+		{UUID: "draft", ID: "acct", IsSchedule: true},
+	}
+	schedules := []Schedule{
+		{PostID: "due", TypeSchedule: Daily, Times: []time.Time{now}},
+		{PostID: "later", TypeSchedule: Daily, Times: []time.Time{now.Add(time.Hour)}},
+		{PostID: "draft", TypeSchedule: Daily, Times: []time.Time{now}},
+	}
+
+	ready, deferred, err := limits.SelectDuePosts(ctx, "acct", posts, schedules, now)
+	require.NoError(t, err)
+	require.Len(t, ready, 1)
+	assert.Equal(t, "due", ready[0].UUID)
+	require.Len(t, deferred, 1)
+	assert.Equal(t, "unscheduled", deferred[0].Post.UUID)
+	assert.Equal(t, now.Add(10*time.Minute), deferred[0].At)
+	assert.True(t, schedules[0].DeferredUntil.IsZero())
+
+	// 制限中は予定日時のPostを延期し、延期した日時に選択する
+	require.NoError(t, limits.Record(ctx, XRateLimit{
+		AccountID: "acct", Endpoint: XEndpointPostTweet,
+		Limit: 50, Remaining: 0, Reset: now.Add(10 * time.Minute), UpdatedAt: now,
+	}))
+	ready, deferred, err = limits.SelectDuePosts(ctx, "acct", posts, schedules, now)
+	require.NoError(t, err)
+	assert.Empty(t, ready)
+	assert.Len(t, deferred, 2)
+	assert.Equal(t, now.Add(10*time.Minute), schedules[0].DeferredUntil)
+	assert.True(t, schedules[1].DeferredUntil.IsZero())
+
+	at := now.Add(10 * time.Minute)
+	ready, _, err = limits.SelectDuePosts(ctx, "acct", posts, schedules, at)
+	require.NoError(t, err)
+	got := []string{}
+	for _, v := range ready {
+		got = append(got, v.UUID)
+	}
+	assert.Equal(t, []string{"due", "unscheduled"}, got)
+	assert.True(t, schedules[0].DeferredUntil.IsZero())
+}
+
+func TestXRateLimitStoreRecordKeepsUserLimit(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	limits := XRateLimitStore{Store: NewMemoryStore(), Collection: "x_rate_limits"}
+
+	h := xHeader(
+		"x-rate-limit-limit", "50", "x-rate-limit-remaining", "49", "x-rate-limit-reset", strconv.FormatInt(now.Add(15*time.Minute).Unix(), 10),
+		"x-user-limit-24hour-limit", "17", "x-user-limit-24hour-remaining", "3", "x-user-limit-24hour-reset", strconv.FormatInt(now.Add(time.Hour).Unix(), 10),
+	)
+	require.NoError(t, limits.Record(ctx, ParseXRateLimit("acct", XEndpointPostTweet, http.StatusOK, h, now)))
+
+	// ユーザー単位のヘッダが無いレスポンス
+	h = xHeader("x-rate-limit-limit", "50", "x-rate-limit-remaining", "48", "x-rate-limit-reset", strconv.FormatInt(now.Add(15*time.Minute).Unix(), 10))
+	require.NoError(t, limits.Record(ctx, ParseXRateLimit("acct", XEndpointPostTweet, http.StatusOK, h, now.Add(time.Minute))))
+
+	got, err := limits.Get(ctx, "acct", XEndpointPostTweet)
+	require.NoError(t, err)
+	assert.Equal(t, 48, got.Remaining)
+	assert.Equal(t, 17, got.UserLimit)
+	assert.Equal(t, 3, got.UserRemaining)
+	assert.Equal(t, now.Add(time.Hour).Unix(), got.UserReset.Unix())
+	assert.Equal(t, 3, got.Available(now.Add(time.Minute)))
+}
@@ -1,6 +1,9 @@
 package models
 
 import (
+	"errors"
+	"fmt"
+	"regexp"
 	"strings"
 	"time"
 
@@ -19,11 +22,11 @@ const (
 type Account struct {
 	UUID         string `csv:"uuid" dataframe:"uuid" firestore:"uuid,omitempty" json:"uuid,omitempty"`
 	ID           string `csv:"id" dataframe:"id" firestore:"id,omitempty" json:"id,omitempty"`
-	Password     string `csv:"password" dataframe:"password" firestore:"password,omitempty" json:"password,omitempty"`
-	Tel          string `csv:"tel" dataframe:"tel" firestore:"tel,omitempty" json:"tel,omitempty"`
+	Password     string `csv:"password" dataframe:"password" firestore:"password,omitempty" json:"password,omitempty" export:"-"`
+	Tel          string `csv:"tel" dataframe:"tel" firestore:"tel,omitempty" json:"tel,omitempty" export:"mask"`
 	SpreadID     string `csv:"spread_id" dataframe:"spread_id" firestore:"spread_id,omitempty" json:"spread_id,omitempty"`
-	AccessToken  string `csv:"access_token" dataframe:"access_token" firestore:"access_token,omitempty" json:"access_token,omitempty"`
-	AccessSecret string `csv:"access_secret" dataframe:"access_secret" firestore:"access_secret,omitempty" json:"access_secret,omitempty"`
+	AccessToken  string `csv:"access_token" dataframe:"access_token" firestore:"access_token,omitempty" json:"access_token,omitempty" export:"-"`
+	AccessSecret string `csv:"access_secret" dataframe:"access_secret" firestore:"access_secret,omitempty" json:"access_secret,omitempty" export:"-"`
 
 	CreatedAt time.Time `csv:"created_at" dataframe:"created_at" firestore:"created_at,omitempty" json:"created_at,omitempty"`
 }
@@ -55,3 +58,133 @@ func (p Account) CleanTelphoneNumber() string {
 	s = strings.ReplaceAll(s, "―", "")
 	return strings.ReplaceAll(s, "ー", "")
 }
+
+var (
+	// E.164: +と国番号から始まる最大15桁
+	regexpE164 = regexp.MustCompile(`^\+[1-9][0-9]{7,14}$`)
+	// X ID: 数値のユーザーID、または英数字とアンダースコアのスクリーンネーム
+	regexpXID = regexp.MustCompile(`^([0-9]{1,20}|[A-Za-z0-9_]{1,15})$`)
+	// Google Sheets ID: 英数字, -, _ で構成される
+	regexpSpreadID = regexp.MustCompile(`^[A-Za-z0-9_-]{25,100}$`)
+	// https://docs.google.com/spreadsheets/d/{SpreadID}/edit
+	regexpSpreadURL = regexp.MustCompile(`/spreadsheets/d/([A-Za-z0-9_-]+)`)
+)
+
+// CountryCodeJP 国内番号(0始まり)をE.164に変換する際の国番号
+const CountryCodeJP = "81"
+
+// InternationalPrefixJP 日本の国際電話のプレフィックス
+// 00は国内の事業者番号(0033など)と重なるため、国際プレフィックスとして扱わない
+const InternationalPrefixJP = "010"
+
+var ErrInvalidTel = errors.New("invalid telephone number")
+
+// NormalizeTel 電話番号をE.164形式に変換します
+// ０９０－１２３４－５６７８ -> +819012345678
+func NormalizeTel(tel string) (string, error) {
+	s := ToHalfWidth(strings.TrimSpace(tel))
+	if s == "" {
+		return "", nil
+	}
+
+	// 数字と先頭の+以外を除去
+	// ハイフン、長音、括弧、スペースなどの区切り文字を許容する
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '+' && i == 0:
+			b.WriteRune(r)
+		case strings.ContainsRune(" -‐―−ー().", r):
+			continue
+		default:
+			return "", fmt.Errorf("%w: unexpected character %q", ErrInvalidTel, r)
+		}
+	}
+	s = b.String()
+
+	switch {
+	case strings.HasPrefix(s, "+"+CountryCodeJP+"0"):
+		// +81 090-... 国番号の後の国内プレフィックス(0)を除去
+		s = "+" + CountryCodeJP + s[len(CountryCodeJP)+2:]
+	case strings.HasPrefix(s, "+"):
+	case strings.HasPrefix(s, InternationalPrefixJP):
+		// 国内からの国際電話: 010-1-212-... -> +1212...
+		s = "+" + s[len(InternationalPrefixJP):]
+	case strings.HasPrefix(s, "00"):
+		// 事業者番号付き、または海外の国際プレフィックス
+		return "", fmt.Errorf("%w: unsupported prefix: %s", ErrInvalidTel, tel)
+	case strings.HasPrefix(s, "0"):
+		// 国内番号: 先頭の0を国番号に置き換える
+		s = "+" + CountryCodeJP + s[1:]
+	default:
+		return "", fmt.Errorf("%w: %s", ErrInvalidTel, tel)
+	}
+
+	if !regexpE164.MatchString(s) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidTel, tel)
+	}
+	return s, nil
+}
+
+// NormalizeXID @付きのスクリーンネームや前後の空白を除去します
+func NormalizeXID(id string) string {
+	s := ToHalfWidth(strings.TrimSpace(id))
+	return strings.TrimPrefix(s, "@")
+}
+
+// NormalizeSpreadID URLで渡された場合もSpreadIDを取り出します
+func NormalizeSpreadID(id string) string {
+	s := strings.TrimSpace(id)
+	if m := regexpSpreadURL.FindStringSubmatch(s); len(m) == 2 {
+		return m[1]
+	}
+	return s
+}
+
+// Normalize 登録前にアカウント情報を正規化します
+// 変換できない電話番号はそのまま残し、Validateでエラーとして扱う
+func (p *Account) Normalize() *Account {
+	p.ID = NormalizeXID(p.ID)
+	p.SpreadID = NormalizeSpreadID(p.SpreadID)
+	p.AccessToken = strings.TrimSpace(p.AccessToken)
+	p.AccessSecret = strings.TrimSpace(p.AccessSecret)
+
+	if tel, err := NormalizeTel(p.Tel); err == nil {
+		p.Tel = tel
+	}
+
+	return p
+}
+
+// Validate アカウント情報を検証し、エラーがあればValidationErrorsを返します
+// Normalize後の値を前提とする
+func (p Account) Validate() error {
+	var errs ValidationErrors
+
+	if p.ID == "" {
+		errs = append(errs, ValidationError{Field: "id", Reason: "is required"})
+	} else if !regexpXID.MatchString(p.ID) {
+		errs = append(errs, ValidationError{Field: "id", Value: p.ID, Reason: "is not a valid x id"})
+	}
+
+	if p.Tel != "" && !regexpE164.MatchString(p.Tel) {
+		errs = append(errs, ValidationError{Field: "tel", Value: p.Tel, Reason: "is not e.164 format"})
+	}
+
+	if p.SpreadID != "" && !regexpSpreadID.MatchString(p.SpreadID) {
+		errs = append(errs, ValidationError{Field: "spread_id", Value: p.SpreadID, Reason: "is not a valid google sheets id"})
+	}
+
+	// token, secretは両方揃っているか、両方空であること
+	if (p.AccessToken == "") != (p.AccessSecret == "") {
+		field := "access_secret"
+		if p.AccessToken == "" {
+			field = "access_token"
+		}
+		errs = append(errs, ValidationError{Field: field, Reason: "access_token and access_secret must be set together"})
+	}
+
+	return errs.Err()
+}
@@ -3,12 +3,12 @@ package models
 type Claims struct {
 	ID string `firestore:"id" json:"id,omitempty"`
 
-	AccessToken  string `firestore:"access_token" json:"access_token,omitempty"`
-	AccessSecret string `firestore:"access_secret" json:"access_secret,omitempty"`
+	AccessToken  string `firestore:"access_token" json:"access_token,omitempty" export:"-"`
+	AccessSecret string `firestore:"access_secret" json:"access_secret,omitempty" export:"-"`
 
 	// Auth Request Token
-	RequestToken       string `firestore:"request_token" json:"request_token,omitempty"`
-	RequestTokenSecret string `firestore:"request_token_secret" json:"request_token_secret,omitempty"`
+	RequestToken       string `firestore:"request_token" json:"request_token,omitempty" export:"-"`
+	RequestTokenSecret string `firestore:"request_token_secret" json:"request_token_secret,omitempty" export:"-"`
 
 	// Referer URL
 	Ref string `firestore:"ref" json:"ref,omitempty"`
@@ -11,3 +11,8 @@ type Group struct {
 
 	ChildPostIds []string `firestore:"post_id,omitempty" csv:"-" json:"child_post_ids,omitempty"`
 }
+
+// GetOwnerID for interface
+func (p Group) GetOwnerID() string {
+	return p.OwnerId
+}
@@ -0,0 +1,274 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RowChange is a change of spreadsheet row between imports.
+type RowChange uint8
+
+const (
+	RowUnchanged RowChange = iota
+	RowMoved               // 内容は同じで行が移動
+	RowUpdated             // 同じ行で内容が変更
+	RowInserted            // 新しい行
+	RowDeleted             // 前回の取り込み後に削除された行
+)
+
+func (p RowChange) String() string {
+	switch p {
+	case RowMoved:
+		return "moved"
+	case RowUpdated:
+		return "updated"
+	case RowInserted:
+		return "inserted"
+	case RowDeleted:
+		return "deleted"
+	}
+	return "unchanged"
+}
+
+// RowDiff is a matching result of a row.
+type RowDiff struct {
+	UUID   string    `json:"uuid"`
+	Row    int       `json:"row,omitempty"`
+	Prev   int       `json:"prev,omitempty"`
+	Change RowChange `json:"change"`
+	Post   Post      `json:"-"`
+}
+
+// rowLink is a link between Post UUID and spreadsheet row in SyncState.
+type rowLink struct {
+	UUID string
+	Row  int
+	Hash string
+}
+
+// links 行番号順のリンクを返します
+func (p SyncState) links() []rowLink {
+	links := make([]rowLink, 0, len(p.Rows))
+	for id, v := range p.Rows {
+		links = append(links, rowLink{UUID: id, Row: v.Row, Hash: v.Content})
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].Row != links[j].Row {
+			return links[i].Row < links[j].Row
+		}
+		return links[i].UUID < links[j].UUID
+	})
+	return links
+}
+
+// Match 取り込む行と前回の同期状態を照合します
+// 照合の優先順位: uuidカラム -> 内容のハッシュ(移動) -> 行番号(内容の変更)
+// いずれにも一致しない行は新規、残ったリンクは削除とする
+// positionsはヘッダーのカラム位置、ハッシュはシートにあるカラムのみで計算する、nilは全カラム
+func (p SyncState) Match(rows []RowPost, positions map[string]int) []RowDiff {
+	diffs := make([]RowDiff, len(rows))
+	matched := make([]bool, len(rows))
+	used := make(map[string]bool)
+	links := p.links()
+
+	link := func(i int, l rowLink, hash string) {
+		change := RowUnchanged
+		switch {
+		case l.Hash != hash:
+			change = RowUpdated
+		case l.Row != rows[i].Row:
+			change = RowMoved
+		}
+		diffs[i] = RowDiff{UUID: l.UUID, Row: rows[i].Row, Prev: l.Row, Change: change, Post: rows[i].Post}
+		matched[i] = true
+		used[l.UUID] = true
+	}
+
+	hashes := make([]string, len(rows))
+	for i, r := range rows {
+		hashes[i] = contentHash(r.Post, positions)
+	}
+
+	// uuidカラム
+	for i, r := range rows {
+		if v, ok := p.Rows[r.Post.UUID]; ok && r.Post.UUID != "" && !used[r.Post.UUID] {
+			link(i, rowLink{UUID: r.Post.UUID, Row: v.Row, Hash: v.Content}, hashes[i])
+		}
+	}
+
+	// 内容のハッシュ、同じ内容の行は行番号が近いものを優先する
+	for i, r := range rows {
+		if matched[i] {
+			continue
+		}
+		best, distance := -1, 0
+		for j, l := range links {
+			if used[l.UUID] || l.Hash != hashes[i] {
+				continue
+			}
+			d := l.Row - r.Row
+			if d < 0 {
+				d = -d
+			}
+			if best < 0 || d < distance {
+				best, distance = j, d
+			}
+		}
+		if best >= 0 {
+			link(i, links[best], hashes[i])
+		}
+	}
+
+	// 行番号
+	byRow := make(map[int]rowLink)
+	for _, l := range links {
+		if !used[l.UUID] {
+			byRow[l.Row] = l
+		}
+	}
+	for i, r := range rows {
+		if matched[i] {
+			continue
+		}
+		if l, ok := byRow[r.Row]; ok && !used[l.UUID] {
+			link(i, l, hashes[i])
+		}
+	}
+
+	// 新規
+	for i, r := range rows {
+		if matched[i] {
+			continue
+		}
+		id := r.Post.UUID
+		if id == "" || used[id] {
+			id = uuid.New().String()
+		}
+		used[id] = true
+		diffs[i] = RowDiff{UUID: id, Row: r.Row, Change: RowInserted, Post: r.Post}
+	}
+
+	// 削除
+	for _, l := range links {
+		if !used[l.UUID] {
+			diffs = append(diffs, RowDiff{UUID: l.UUID, Prev: l.Row, Change: RowDeleted})
+		}
+	}
+
+	return diffs
+}
+
+// Apply 照合結果から行番号と内容のハッシュを更新します
+// 投稿結果のハッシュはSheetSyncが使用するため保持する
+func (p *SyncState) Apply(diffs []RowDiff, positions map[string]int, now time.Time) {
+	rows := make(map[string]SyncSnapshot, len(diffs))
+	for _, v := range diffs {
+		if v.Change == RowDeleted {
+			continue
+		}
+		snapshot := p.Rows[v.UUID]
+		snapshot.Row = v.Row
+		snapshot.Content = contentHash(v.Post, positions)
+		rows[v.UUID] = snapshot
+	}
+	p.Rows = rows
+	p.SyncedAt = now
+}
+
+// Lookup UUIDから行番号を返します、結果の書き戻しに使用する
+func (p SyncState) Lookup(postID string) (int, bool) {
+	v, ok := p.Rows[postID]
+	if !ok {
+		return 0, false
+	}
+	return v.Row, true
+}
+
+// SheetImporter imports sheet rows into Posts, keeping row identity.
+// 行の対応付けはSheetSyncと同じSyncStateに保存する
+// why: Setで取り込むたびにUUIDが振り直され、再取り込みで重複し、結果を元の行へ書き戻せなかったため
+type SheetImporter struct {
+	Store Store
+
+	// Collection is posts collection name.
+	Collection string
+	// StateCollection is collection name for SyncState, same as SheetSync.
+	StateCollection string
+
+	// DeleteMissing ゴミ箱に移動する、削除された行のPost
+	DeleteMissing bool
+}
+
+// Import 行をPostとして取り込みます
+// 前回の取り込みと照合し、既存のPostは内容のみ更新、投稿結果とUUIDは保持する
+// 他のアカウントのPostと同じUUIDの行は、新しいUUIDのPostとして作成する
+// positionsはヘッダーのカラム位置、シートに無いカラムは既存のPostの値を保持する
+func (p *SheetImporter) Import(ctx context.Context, accountID, spreadID, sheet string, positions map[string]int, rows []RowPost) ([]RowDiff, error) {
+	state, err := loadSyncState(ctx, p.Store, p.StateCollection, spreadID, sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	diffs := state.Match(rows, positions)
+	for i, v := range diffs {
+		switch v.Change {
+		case RowDeleted:
+			if !p.DeleteMissing {
+				continue
+			}
+			var post Post
+			if err := p.Store.Get(ctx, p.Collection, v.UUID, &post); err != nil {
+				if errors.Is(err, ErrNotFound) {
+					continue
+				}
+				return nil, err
+			}
+			if post.ID != accountID {
+				continue
+			}
+			if err := DeletePost(ctx, p.Store, p.Collection, v.UUID, "sheet:"+spreadID); err != nil && !errors.Is(err, ErrNotFound) {
+				return nil, err
+			}
+			continue
+		case RowUnchanged, RowMoved:
+			continue
+		}
+
+		var post Post
+		err := p.Store.Get(ctx, p.Collection, v.UUID, &post)
+		switch {
+		case err == nil && post.ID == accountID:
+			applyContent(&post, v.Post, positions)
+		case err == nil:
+			// 他のアカウントのPost
+			diffs[i].UUID = uuid.New().String()
+			diffs[i].Change = RowInserted
+			fallthrough
+		case errors.Is(err, ErrNotFound):
+			post = v.Post
+			post.UUID = diffs[i].UUID
+			post.ID = accountID
+			post.CreatedAt = time.Time{}
+			post.SetCreateAt()
+		default:
+			return nil, err
+		}
+
+		if err := p.Store.Set(ctx, p.Collection, post.UUID, post); err != nil {
+			return nil, err
+		}
+		diffs[i].Post = post
+	}
+
+	state.Apply(diffs, positions, now)
+	if err := p.Store.Set(ctx, p.StateCollection, SyncStateKey(spreadID, sheet), state); err != nil {
+		return nil, err
+	}
+
+	return diffs, nil
+}
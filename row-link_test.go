@@ -0,0 +1,42 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSheetImporterPartialColumns(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	importer := &SheetImporter{Store: store, Collection: "posts", StateCollection: "sync_states"}
+	positions := PostSchema().Positions([]string{"uuid", "text"})
+
+	stored := Post{UUID: "u1", ID: "acct", Text: "before", Priority: 5, File1: "a.png", IsSchedule: true}
+	require.NoError(t, store.Set(ctx, "posts", stored.UUID, stored))
+
+	diffs, err := importer.Import(ctx, "acct", "spread", "posts", positions, []RowPost{
+		{Row: 2, Post: Post{UUID: "u1", Text: "after"}},
+	})
+	require.NoError(t, err)
+	require.Len(t, diffs, 1)
+	assert.Equal(t, RowInserted, diffs[0].Change)
+
+	var got Post
+	require.NoError(t, store.Get(ctx, "posts", "u1", &got))
+	assert.Equal(t, "after", got.Text)
+	assert.Equal(t, 5, got.Priority)
+	assert.Equal(t, "a.png", got.File1)
+	assert.True(t, got.IsSchedule)
+
+	// シートに無いカラムはハッシュに含めない
+	diffs, err = importer.Import(ctx, "acct", "spread", "posts", positions, []RowPost{
+		{Row: 3, Post: Post{Text: "after"}},
+	})
+	require.NoError(t, err)
+	require.Len(t, diffs, 1)
+	assert.Equal(t, "u1", diffs[0].UUID)
+	assert.Equal(t, RowMoved, diffs[0].Change)
+}
@@ -0,0 +1,378 @@
+package models
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/go-gota/gota/dataframe"
+)
+
+// CellError is an error of a cell.
+type CellError struct {
+	// Sheet is sheet name, empty for csv and dataframe.
+	Sheet string `json:"sheet,omitempty"`
+	// Row is 1-based row number including header row, as shown in spreadsheet.
+	Row    int    `json:"row"`
+	Column string `json:"column,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (e CellError) Error() string {
+	prefix := fmt.Sprintf("row %d", e.Row)
+	if e.Sheet != "" {
+		prefix = fmt.Sprintf("sheet %s, row %d", e.Sheet, e.Row)
+	}
+	if e.Column == "" {
+		return fmt.Sprintf("%s: %s", prefix, e.Reason)
+	}
+	return fmt.Sprintf("%s, column %s: %s (%q)", prefix, e.Column, e.Reason, e.Value)
+}
+
+// ImportReport is a result of importing rows.
+type ImportReport struct {
+	// Rows is the number of data rows, excluding header and blank rows.
+	Rows     int `json:"rows"`
+	Imported int `json:"imported"`
+
+	MissingColumns []string    `json:"missing_columns,omitempty"`
+	UnknownColumns []string    `json:"unknown_columns,omitempty"`
+	Errors         []CellError `json:"errors,omitempty"`
+}
+
+// Err エラーが無い場合はnilを返す
+func (p *ImportReport) Err() error {
+	if p == nil || len(p.Errors) == 0 {
+		return nil
+	}
+	s := make([]string, len(p.Errors))
+	for i, v := range p.Errors {
+		s[i] = v.Error()
+	}
+	return fmt.Errorf("import failed: %s", strings.Join(s, ", "))
+}
+
+// ImportPosts ヘッダーと行からPostを生成します
+// カラムは名前で対応付け、未知のカラムは無視、必須でないカラムの欠落は許容する
+// エラーのある行は取り込まず、ImportReportにセル単位で記録する
+func ImportPosts(header []string, rows [][]string) ([]Post, *ImportReport) {
+	return ImportPostsWithSchema(PostSchema(), header, rows)
+}
+
+// ImportPostsWithSchema ヘッダーの別名を追加したSchemaでPostを生成します
+func ImportPostsWithSchema(schema Schema, header []string, rows [][]string) ([]Post, *ImportReport) {
+	rowPosts, report := ImportPostRows(schema, header, rows)
+	if rowPosts == nil {
+		return nil, report
+	}
+
+	posts := make([]Post, len(rowPosts))
+	for i, v := range rowPosts {
+		posts[i] = v.Post
+	}
+	return posts, report
+}
+
+// RowPost is a Post with its row number in spreadsheet.
+type RowPost struct {
+	// Row is 1-based row number including header row.
+	Row  int
+	Post Post
+}
+
+// ImportPostRows 行番号付きでPostを生成します
+// シートへの書き戻しなど、元の行を特定する必要がある場合に使用する
+func ImportPostRows(schema Schema, header []string, rows [][]string) ([]RowPost, *ImportReport) {
+	report := &ImportReport{}
+
+	// ヘッダー位置 -> カラム
+	columns := []headerColumn{}
+	found := make(map[string]bool)
+	for i, h := range header {
+		if strings.TrimSpace(h) == "" {
+			continue
+		}
+		c, ok := schema.Lookup(h)
+		if !ok {
+			report.UnknownColumns = append(report.UnknownColumns, h)
+			continue
+		}
+		if found[c.Name] {
+			report.Errors = append(report.Errors, CellError{Row: 1, Column: c.Name, Value: h, Reason: "duplicate column"})
+			continue
+		}
+		found[c.Name] = true
+		columns = append(columns, headerColumn{Column: c, position: i})
+	}
+
+	for _, c := range schema.Columns {
+		if found[c.Name] {
+			continue
+		}
+		report.MissingColumns = append(report.MissingColumns, c.Name)
+		if c.Required {
+			report.Errors = append(report.Errors, CellError{Row: 1, Column: c.Name, Reason: "required column is missing"})
+		}
+	}
+	if len(report.Errors) > 0 {
+		return nil, report
+	}
+
+	posts := []RowPost{}
+	for i, row := range rows {
+		if isBlankRow(row) {
+			continue
+		}
+		report.Rows++
+
+		// 1行目はヘッダー
+		rowNumber := i + 2
+		post, errs := decodePostRow(columns, row, rowNumber)
+		if len(errs) > 0 {
+			report.Errors = append(report.Errors, errs...)
+			continue
+		}
+		posts = append(posts, RowPost{Row: rowNumber, Post: post})
+	}
+	report.Imported = len(posts)
+
+	return posts, report
+}
+
+// headerColumn is a Column with its position in header row.
+type headerColumn struct {
+	Column
+	position int
+}
+
+func decodePostRow(columns []headerColumn, row []string, rowNumber int) (Post, []CellError) {
+	var (
+		post Post
+		errs []CellError
+	)
+	v := reflect.ValueOf(&post).Elem()
+
+	for _, c := range columns {
+		value := ""
+		if c.position < len(row) {
+			value = row[c.position]
+		}
+
+		if c.Required && strings.TrimSpace(value) == "" {
+			errs = append(errs, CellError{Row: rowNumber, Column: c.Name, Reason: "is required"})
+			continue
+		}
+
+		field := v.Field(c.index)
+		switch c.kind {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Int:
+			n, err := ParseInt(value)
+			if err != nil {
+				// checkedなどフラグとして使われる数値カラムは、真偽値の表記も許容する
+				if b, berr := ParseBool(value); berr == nil && c.Flag {
+					if b {
+						field.SetInt(1)
+					} else {
+						field.SetInt(0)
+					}
+					continue
+				}
+				errs = append(errs, CellError{Row: rowNumber, Column: c.Name, Value: value, Reason: "is not a number"})
+				continue
+			}
+			field.SetInt(int64(n))
+		case reflect.Bool:
+			b, err := ParseBool(value)
+			if err != nil {
+				errs = append(errs, CellError{Row: rowNumber, Column: c.Name, Value: value, Reason: "is not a boolean"})
+				continue
+			}
+			field.SetBool(b)
+		}
+	}
+
+	return post, errs
+}
+
+// ParseBool スプレッドシートで使われる真偽値の表記を解釈します
+// TRUE, 1, ○, yes などをtrue、空欄、FALSE, 0, ×, no などをfalseとする
+func ParseBool(s string) (bool, error) {
+	switch strings.ToLower(ToHalfWidth(strings.TrimSpace(s))) {
+	case "true", "t", "1", "yes", "y", "on", "○", "◯", "〇", "✓", "✔":
+		return true, nil
+	case "", "false", "f", "0", "no", "n", "off", "×", "✕", "-":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean: %s", s)
+}
+
+// ParseInt 全角数字、桁区切りを含む数値を解釈します、空欄は0
+func ParseInt(s string) (int, error) {
+	s = strings.ReplaceAll(ToHalfWidth(strings.TrimSpace(s)), ",", "")
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
+
+func isBlankRow(row []string) bool {
+	for _, v := range row {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// ImportPostsCSV CSVからPostを生成します
+func ImportPostsCSV(r io.Reader) ([]Post, *ImportReport, error) {
+	return ImportPostsCSVWithSchema(PostSchema(), r)
+}
+
+// ImportPostsCSVWithSchema ヘッダーの別名を追加したSchemaでCSVからPostを生成します
+func ImportPostsCSVWithSchema(schema Schema, r io.Reader) ([]Post, *ImportReport, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading csv: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, nil, fmt.Errorf("error reading csv: header row is missing")
+	}
+
+	// BOM付きCSV(Excel)の場合、先頭カラム名からBOMを除去
+	records[0][0] = strings.TrimPrefix(records[0][0], "\ufeff")
+
+	posts, report := ImportPostsWithSchema(schema, records[0], records[1:])
+	return posts, report, nil
+}
+
+// ImportPostsDataFrame dataframeからPostを生成します
+// CheckColumnsと異なり、カラムの順序や過不足を許容する
+func ImportPostsDataFrame(df dataframe.DataFrame) ([]Post, *ImportReport, error) {
+	return ImportPostsDataFrameWithSchema(PostSchema(), df)
+}
+
+// ImportPostsDataFrameWithSchema ヘッダーの別名を追加したSchemaでdataframeからPostを生成します
+func ImportPostsDataFrameWithSchema(schema Schema, df dataframe.DataFrame) ([]Post, *ImportReport, error) {
+	if df.Err != nil {
+		return nil, nil, df.Err
+	}
+
+	records := df.Records()
+	if len(records) == 0 {
+		return nil, nil, fmt.Errorf("error reading dataframe: header row is missing")
+	}
+
+	// 欠損値はNaNとして出力されるため、空欄として扱う
+	for _, row := range records[1:] {
+		for i, v := range row {
+			if v == "NaN" {
+				row[i] = ""
+			}
+		}
+	}
+
+	posts, report := ImportPostsWithSchema(schema, records[0], records[1:])
+	return posts, report, nil
+}
+
+// ExportPostsCSV PostをPostSchemaのカラム順でCSVに書き出します
+// ヘッダーはlocaleの表示名、LocaleENはcsvタグ名(CheckColumnsと同じ)
+func ExportPostsCSV(w io.Writer, posts []Post, locale Locale) error {
+	return ExportPostsCSVWithSchema(PostSchema(), w, posts, locale)
+}
+
+// ExportPostsCSVWithSchema 表示名を変更したSchemaでPostをCSVに書き出します
+func ExportPostsCSVWithSchema(schema Schema, w io.Writer, posts []Post, locale Locale) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write(schema.Header(locale)); err != nil {
+		return fmt.Errorf("error writing csv: %v", err)
+	}
+	for _, post := range posts {
+		if err := writer.Write(encodePostRow(schema, post)); err != nil {
+			return fmt.Errorf("error writing csv: %v", err)
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func encodePostRow(schema Schema, post Post) []string {
+	v := reflect.ValueOf(post)
+	row := make([]string, len(schema.Columns))
+	for i, c := range schema.Columns {
+		field := v.Field(c.index)
+		switch c.kind {
+		case reflect.String:
+			row[i] = field.String()
+		case reflect.Int:
+			row[i] = strconv.FormatInt(field.Int(), 10)
+		case reflect.Bool:
+			row[i] = strings.ToUpper(strconv.FormatBool(field.Bool()))
+		}
+	}
+	return row
+}
+
+// SheetTable is a sheet of workbook, first row is header.
+type SheetTable struct {
+	Name string
+	Rows [][]string
+	// RowNumbers is 1-based row number in the sheet of each Rows, nil if Rows are not collapsed.
+	// 空行の繰り返しをまとめた場合に、エラーの行番号をシート上の行番号とするため
+	RowNumbers []int
+}
+
+// rowNumber Rowsの1始まりの行番号をシート上の行番号に変換します
+func (p SheetTable) rowNumber(row int) int {
+	if row < 1 || row > len(p.RowNumbers) {
+		return row
+	}
+	return p.RowNumbers[row-1]
+}
+
+// SheetImport is a result of importing a sheet.
+type SheetImport struct {
+	Sheet  string        `json:"sheet"`
+	Posts  []Post        `json:"-"`
+	Report *ImportReport `json:"report"`
+}
+
+// importSheets シートごとにPostを生成します
+// 空のシートは対象外とする
+func importSheets(schema Schema, tables []SheetTable) []SheetImport {
+	results := []SheetImport{}
+	for _, table := range tables {
+		if len(table.Rows) == 0 || isBlankRow(table.Rows[0]) {
+			continue
+		}
+
+		posts, report := ImportPostsWithSchema(schema, table.Rows[0], table.Rows[1:])
+		for i := range report.Errors {
+			report.Errors[i].Sheet = table.Name
+			report.Errors[i].Row = table.rowNumber(report.Errors[i].Row)
+		}
+		// セル内改行はCRLFの場合があるため、LFに統一
+		for i := range posts {
+			posts[i].Text = strings.ReplaceAll(posts[i].Text, "\r\n", "\n")
+		}
+
+		results = append(results, SheetImport{
+			Sheet:  table.Name,
+			Posts:  posts,
+			Report: report,
+		})
+	}
+	return results
+}
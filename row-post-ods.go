@@ -0,0 +1,325 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	odsMimeType = "application/vnd.oasis.opendocument.spreadsheet"
+
+	nsTable  = "urn:oasis:names:tc:opendocument:xmlns:table:1.0"
+	nsText   = "urn:oasis:names:tc:opendocument:xmlns:text:1.0"
+	nsOffice = "urn:oasis:names:tc:opendocument:xmlns:office:1.0"
+
+	// odsMaxRepeat 繰り返し指定の上限、空行・空列は1件にまとめ、値のある行・列は読み込みエラーとする
+	// why: 書式のみの空行・空列が数万件の繰り返しとして保存されることがあるため
+	odsMaxRepeat = 1024
+)
+
+// ImportPostsODS ODSの各シートからPostを生成します
+// sheetsを指定した場合は該当シートのみ、未指定の場合は全シートを対象とする
+func ImportPostsODS(r io.ReaderAt, size int64, sheets ...string) ([]SheetImport, error) {
+	return ImportPostsODSWithSchema(PostSchema(), r, size, sheets...)
+}
+
+// ImportPostsODSWithSchema ヘッダーの別名を追加したSchemaでODSからPostを生成します
+func ImportPostsODSWithSchema(schema Schema, r io.ReaderAt, size int64, sheets ...string) ([]SheetImport, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("error reading ods: %v", err)
+	}
+
+	var content *zip.File
+	for _, f := range zr.File {
+		if f.Name == "content.xml" {
+			content = f
+			break
+		}
+	}
+	if content == nil {
+		return nil, fmt.Errorf("error reading ods: content.xml is missing")
+	}
+
+	rc, err := content.Open()
+	if err != nil {
+		return nil, fmt.Errorf("error reading ods: %v", err)
+	}
+	defer rc.Close()
+
+	tables, err := readODSContent(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sheets) > 0 {
+		selected := []SheetTable{}
+		for _, t := range tables {
+			for _, name := range sheets {
+				if t.Name == name {
+					selected = append(selected, t)
+				}
+			}
+		}
+		tables = selected
+	}
+
+	return importSheets(schema, tables), nil
+}
+
+// readODSContent content.xmlからシートを読み込みます
+func readODSContent(r io.Reader) ([]SheetTable, error) {
+	var (
+		tables []SheetTable
+		table  *SheetTable
+		row    []string
+		rowRep int
+		// rowNumber is the last row number in the sheet, before collapsing blank rows.
+		rowNumber int
+
+		// セルの読み込み状態
+		inCell    bool
+		cellRep   int
+		cellValue string
+		cellText  strings.Builder
+		paragraph int
+	)
+
+	attr := func(e xml.StartElement, space, local string) string {
+		for _, a := range e.Attr {
+			if a.Name.Space == space && a.Name.Local == local {
+				return a.Value
+			}
+		}
+		return ""
+	}
+	repeat := func(e xml.StartElement, local string) int {
+		n, err := strconv.Atoi(attr(e, nsTable, local))
+		if err != nil || n < 1 {
+			return 1
+		}
+		return n
+	}
+
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading ods: %v", err)
+		}
+
+		switch e := token.(type) {
+		case xml.StartElement:
+			switch {
+			case e.Name.Space == nsTable && e.Name.Local == "table":
+				tables = append(tables, SheetTable{Name: attr(e, nsTable, "name"), RowNumbers: []int{}})
+				table = &tables[len(tables)-1]
+				rowNumber = 0
+			case e.Name.Space == nsTable && e.Name.Local == "table-row":
+				row = []string{}
+				rowRep = repeat(e, "number-rows-repeated")
+			case e.Name.Space == nsTable && (e.Name.Local == "table-cell" || e.Name.Local == "covered-table-cell"):
+				inCell = true
+				cellRep = repeat(e, "number-columns-repeated")
+				cellText.Reset()
+				paragraph = 0
+				cellValue = odsCellValue(e)
+			case inCell && e.Name.Space == nsText && e.Name.Local == "p":
+				// 複数の段落はセル内改行
+				if paragraph > 0 {
+					cellText.WriteString("\n")
+				}
+				paragraph++
+			case inCell && e.Name.Space == nsText && e.Name.Local == "line-break":
+				cellText.WriteString("\n")
+			case inCell && e.Name.Space == nsText && e.Name.Local == "tab":
+				cellText.WriteString("\t")
+			case inCell && e.Name.Space == nsText && e.Name.Local == "s":
+				n, err := strconv.Atoi(attr(e, nsText, "c"))
+				if err != nil || n < 1 {
+					n = 1
+				}
+				cellText.WriteString(strings.Repeat(" ", n))
+			}
+
+		case xml.CharData:
+			if inCell {
+				cellText.Write(e)
+			}
+
+		case xml.EndElement:
+			switch {
+			case e.Name.Space == nsTable && (e.Name.Local == "table-cell" || e.Name.Local == "covered-table-cell"):
+				inCell = false
+				value := cellValue
+				if value == "" {
+					value = cellText.String()
+				}
+				if cellRep > odsMaxRepeat {
+					if value != "" {
+						return nil, fmt.Errorf("error reading ods: row %d: cell repeated %d times, max %d", rowNumber+1, cellRep, odsMaxRepeat)
+					}
+					cellRep = 1
+				}
+				for i := 0; i < cellRep; i++ {
+					row = append(row, value)
+				}
+			case e.Name.Space == nsTable && e.Name.Local == "table-row":
+				if table == nil {
+					continue
+				}
+				row = trimTrailingBlank(row)
+				start := rowNumber + 1
+				rowNumber += rowRep
+				if rowRep > odsMaxRepeat {
+					if len(row) > 0 {
+						return nil, fmt.Errorf("error reading ods: row %d: repeated %d times, max %d", start, rowRep, odsMaxRepeat)
+					}
+					rowRep = 1
+				}
+				for i := 0; i < rowRep; i++ {
+					table.Rows = append(table.Rows, append([]string{}, row...))
+					table.RowNumbers = append(table.RowNumbers, start+i)
+				}
+			case e.Name.Space == nsTable && e.Name.Local == "table":
+				if table != nil {
+					table.Rows = trimTrailingBlankRows(table.Rows)
+					table.RowNumbers = table.RowNumbers[:len(table.Rows)]
+				}
+				table = nil
+			}
+		}
+	}
+
+	return tables, nil
+}
+
+// odsCellValue 型付きセルの値を返します、文字列セルは空を返し本文を使用する
+func odsCellValue(e xml.StartElement) string {
+	values := make(map[string]string)
+	for _, a := range e.Attr {
+		if a.Name.Space == nsOffice {
+			values[a.Name.Local] = a.Value
+		}
+	}
+
+	switch values["value-type"] {
+	case "float", "percentage", "currency":
+		return values["value"]
+	case "boolean":
+		return strings.ToUpper(values["boolean-value"])
+	case "date":
+		v := values["date-value"]
+		if t, err := time.Parse("2006-01-02T15:04:05", v); err == nil {
+			return t.Format("2006-01-02 15:04:05")
+		}
+		return v
+	}
+	return ""
+}
+
+func trimTrailingBlank(row []string) []string {
+	n := len(row)
+	for n > 0 && strings.TrimSpace(row[n-1]) == "" {
+		n--
+	}
+	return row[:n]
+}
+
+func trimTrailingBlankRows(rows [][]string) [][]string {
+	n := len(rows)
+	for n > 0 && isBlankRow(rows[n-1]) {
+		n--
+	}
+	return rows[:n]
+}
+
+// ExportPostsODS PostをODSに書き出します
+func ExportPostsODS(w io.Writer, posts []Post, locale Locale, sheet string) error {
+	return ExportPostsODSWithSchema(PostSchema(), w, posts, locale, sheet)
+}
+
+// ExportPostsODSWithSchema 表示名を変更したSchemaでPostをODSに書き出します
+func ExportPostsODSWithSchema(schema Schema, w io.Writer, posts []Post, locale Locale, sheet string) error {
+	if sheet == "" {
+		sheet = "posts"
+	}
+
+	var content bytes.Buffer
+	content.WriteString(xml.Header)
+	content.WriteString(`<office:document-content xmlns:office="` + nsOffice + `" xmlns:table="` + nsTable + `" xmlns:text="` + nsText + `" office:version="1.2"><office:body><office:spreadsheet>`)
+	content.WriteString(`<table:table table:name="` + xmlEscape(sheet) + `">`)
+
+	writeRow := func(values []any) {
+		content.WriteString(`<table:table-row>`)
+		for _, v := range values {
+			switch v := v.(type) {
+			case int:
+				fmt.Fprintf(&content, `<table:table-cell office:value-type="float" office:value="%d"><text:p>%d</text:p></table:table-cell>`, v, v)
+			default:
+				content.WriteString(`<table:table-cell office:value-type="string">`)
+				for _, line := range strings.Split(fmt.Sprint(v), "\n") {
+					content.WriteString(`<text:p>` + xmlEscape(line) + `</text:p>`)
+				}
+				content.WriteString(`</table:table-cell>`)
+			}
+		}
+		content.WriteString(`</table:table-row>`)
+	}
+
+	writeRow(toAnySlice(schema.Header(locale)))
+	for _, post := range posts {
+		writeRow(encodePostValues(schema, post))
+	}
+	content.WriteString(`</table:table></office:spreadsheet></office:body></office:document-content>`)
+
+	zw := zip.NewWriter(w)
+	// mimetypeは先頭に無圧縮で格納する
+	mw, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return fmt.Errorf("error writing ods: %v", err)
+	}
+	if _, err := mw.Write([]byte(odsMimeType)); err != nil {
+		return fmt.Errorf("error writing ods: %v", err)
+	}
+
+	files := []struct {
+		name string
+		body []byte
+	}{
+		{"META-INF/manifest.xml", []byte(xml.Header + `<manifest:manifest xmlns:manifest="urn:oasis:names:tc:opendocument:xmlns:manifest:1.0" manifest:version="1.2">` +
+			`<manifest:file-entry manifest:full-path="/" manifest:media-type="` + odsMimeType + `"/>` +
+			`<manifest:file-entry manifest:full-path="content.xml" manifest:media-type="text/xml"/>` +
+			`</manifest:manifest>`)},
+		{"content.xml", content.Bytes()},
+	}
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return fmt.Errorf("error writing ods: %v", err)
+		}
+		if _, err := fw.Write(f.body); err != nil {
+			return fmt.Errorf("error writing ods: %v", err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("error writing ods: %v", err)
+	}
+	return nil
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
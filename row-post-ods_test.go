@@ -0,0 +1,70 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// odsContent content.xmlのシートを生成します、rowsはtable-rowの要素
+func odsContent(rows ...string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:table="urn:oasis:names:tc:opendocument:xmlns:table:1.0" xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0">
+<office:body><office:spreadsheet><table:table table:name="posts">` + strings.Join(rows, "") + `</table:table></office:spreadsheet></office:body></office:document-content>`
+}
+
+func odsRow(cells ...string) string {
+	var b strings.Builder
+	b.WriteString("<table:table-row>")
+	for _, v := range cells {
+		b.WriteString("<table:table-cell><text:p>" + v + "</text:p></table:table-cell>")
+	}
+	b.WriteString("</table:table-row>")
+	return b.String()
+}
+
+func TestReadODSContentRowNumbers(t *testing.T) {
+	content := odsContent(
+		odsRow("text", "priority"),
+		odsRow("first", "1"),
+		`<table:table-row table:number-rows-repeated="5000"><table:table-cell/></table:table-row>`,
+		odsRow("second", "x"),
+	)
+
+	tables, err := readODSContent(strings.NewReader(content))
+	require.NoError(t, err)
+	require.Len(t, tables, 1)
+	assert.Equal(t, []int{1, 2, 3, 5003}, tables[0].RowNumbers)
+
+	results := importSheets(PostSchema(), tables)
+	require.Len(t, results, 1)
+	require.Len(t, results[0].Report.Errors, 1)
+	assert.Equal(t, 5003, results[0].Report.Errors[0].Row)
+}
+
+func TestReadODSContentRepeatLimit(t *testing.T) {
+	content := odsContent(
+		odsRow("text"),
+		`<table:table-row table:number-rows-repeated="5000"><table:table-cell><text:p>spam</text:p></table:table-cell></table:table-row>`,
+	)
+	_, err := readODSContent(strings.NewReader(content))
+	assert.ErrorContains(t, err, "row 2")
+
+	content = odsContent(
+		odsRow("text"),
+		`<table:table-row><table:table-cell table:number-columns-repeated="5000"><text:p>spam</text:p></table:table-cell></table:table-row>`,
+	)
+	_, err = readODSContent(strings.NewReader(content))
+	assert.Error(t, err)
+
+	// 上限以内の繰り返しはそのまま読み込む
+	content = odsContent(
+		odsRow("text"),
+		`<table:table-row table:number-rows-repeated="3"><table:table-cell><text:p>same</text:p></table:table-cell></table:table-row>`,
+	)
+	tables, err := readODSContent(strings.NewReader(content))
+	require.NoError(t, err)
+	assert.Len(t, tables[0].Rows, 4)
+}
@@ -0,0 +1,299 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrPlanStale = errors.New("post was changed after plan")
+
+// PlanAction is an action of ImportPlan item.
+type PlanAction uint8
+
+const (
+	PlanNoop PlanAction = iota
+	PlanCreate
+	PlanUpdate
+	PlanDelete
+)
+
+func (p PlanAction) String() string {
+	switch p {
+	case PlanCreate:
+		return "create"
+	case PlanUpdate:
+		return "update"
+	case PlanDelete:
+		return "delete"
+	}
+	return "noop"
+}
+
+// MarshalText JSON出力時に文字列として扱う
+func (p PlanAction) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText 保存した計画を読み込むため
+func (p *PlanAction) UnmarshalText(b []byte) error {
+	for _, v := range []PlanAction{PlanNoop, PlanCreate, PlanUpdate, PlanDelete} {
+		if v.String() == string(b) {
+			*p = v
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown plan action: %s", b)
+}
+
+// FieldChange is a change of a column, by csv or firestore tag name.
+type FieldChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// PlanItem is a planned change of a Post.
+type PlanItem struct {
+	Action PlanAction `json:"action"`
+	UUID   string     `json:"uuid"`
+	// Post is the state after apply, empty for delete.
+	Post    Post          `json:"post"`
+	Changes []FieldChange `json:"changes,omitempty"`
+	// Base is a hash of stored Post at plan time, used to detect changes before apply.
+	Base string `json:"base,omitempty"`
+}
+
+// ImportPlan is a dry-run result of bulk Post import.
+// 保存しておき、後からそのままApplyできる
+type ImportPlan struct {
+	AccountID  string     `json:"account_id"`
+	Collection string     `json:"collection"`
+	Items      []PlanItem `json:"items"`
+	// Positions is Schema.Positions of imported header, nil is all columns.
+	Positions map[string]int `json:"positions,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Plan 取り込み予定のPostと保存済みのPostを比較し、変更内容を返します
+// UUIDで照合し、UUIDの無いPostは本文が一致する保存済みのPostと照合する
+// 取り込み予定に含まれない保存済みのPostは削除(ゴミ箱へ移動)とする
+// positionsは取り込んだヘッダーのSchema.Positions、含まれないカラムは保存済みの値を保持する(nilの場合は全てのカラム)
+func Plan(ctx context.Context, store Store, colName, accountID string, positions map[string]int, incoming []Post) (*ImportPlan, error) {
+	stored, err := ListPosts(ctx, store, colName, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &ImportPlan{
+		AccountID:  accountID,
+		Collection: colName,
+		Positions:  positions,
+		CreatedAt:  time.Now(),
+	}
+
+	schema := PostSchema()
+	byUUID := make(map[string]int, len(stored))
+	for i, v := range stored {
+		byUUID[v.UUID] = i
+	}
+	used := make([]bool, len(stored))
+
+	match := func(post Post) int {
+		if i, ok := byUUID[post.UUID]; ok && post.UUID != "" && !used[i] {
+			return i
+		}
+		if post.UUID != "" {
+			return -1
+		}
+		for i, v := range stored {
+			if !used[i] && v.Text == post.Text {
+				return i
+			}
+		}
+		return -1
+	}
+
+	for _, post := range incoming {
+		// idカラムでアカウントを変更させない
+		post.ID = accountID
+
+		i := match(post)
+		if i < 0 {
+			// 保存済みに無いUUIDは他のアカウントのPostの可能性があるため、振り直す
+			post.UUID = uuid.New().String()
+			plan.Items = append(plan.Items, PlanItem{
+				Action:  PlanCreate,
+				UUID:    post.UUID,
+				Post:    post,
+				Changes: diffPost(schema, Post{}, post),
+			})
+			continue
+		}
+		used[i] = true
+
+		cur := stored[i]
+		next := cur
+		applyColumns(schema, &next, post, positions)
+
+		item := PlanItem{
+			Action:  PlanNoop,
+			UUID:    cur.UUID,
+			Post:    next,
+			Changes: diffPost(schema, cur, next),
+			Base:    postHash(schema, cur),
+		}
+		if len(item.Changes) > 0 {
+			item.Action = PlanUpdate
+		}
+		plan.Items = append(plan.Items, item)
+	}
+
+	for i, v := range stored {
+		if used[i] {
+			continue
+		}
+		plan.Items = append(plan.Items, PlanItem{
+			Action: PlanDelete,
+			UUID:   v.UUID,
+			Base:   postHash(schema, v),
+		})
+	}
+
+	return plan, nil
+}
+
+// applyColumns ヘッダーに含まれるcsvカラムの値を反映します、UUIDとアカウント(id)は保持する
+func applyColumns(schema Schema, dst *Post, src Post, positions map[string]int) {
+	d, s := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src)
+	for _, c := range schema.Columns {
+		if c.Name == "uuid" || c.Name == "id" {
+			continue
+		}
+		if _, ok := positions[c.Name]; positions != nil && !ok {
+			continue
+		}
+		d.Field(c.index).Set(s.Field(c.index))
+	}
+}
+
+func diffPost(schema Schema, from, to Post) []FieldChange {
+	a, b := encodePostRow(schema, from), encodePostRow(schema, to)
+	changes := []FieldChange{}
+	for i, c := range schema.Columns {
+		if c.Name == "uuid" || a[i] == b[i] {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: c.Name, From: a[i], To: b[i]})
+	}
+	return changes
+}
+
+func postHash(schema Schema, post Post) string {
+	return hashFields(encodePostRow(schema, post)...)
+}
+
+// Summary 操作ごとの件数を返します
+func (p ImportPlan) Summary() map[PlanAction]int {
+	summary := make(map[PlanAction]int)
+	for _, v := range p.Items {
+		summary[v.Action]++
+	}
+	return summary
+}
+
+// Apply 計画を適用します
+// 計画作成後に保存済みのPostが変更されていた場合はErrPlanStaleを返し、何も適用しない
+// 更新は適用時のPostを読み込み直し、取り込んだカラムのみ反映する、Statusや投稿結果は保持する
+func (p ImportPlan) Apply(ctx context.Context, store Store, by string) error {
+	schema := PostSchema()
+
+	// 先に全件を確認する
+	for _, v := range p.Items {
+		if v.Action == PlanCreate {
+			var cur Post
+			err := store.Get(ctx, p.Collection, v.UUID, &cur)
+			if err == nil {
+				return fmt.Errorf("%w: %s: %v", ErrPlanStale, v.UUID, ErrAlreadyExists)
+			}
+			if !errors.Is(err, ErrNotFound) {
+				return err
+			}
+			continue
+		}
+		if v.Action != PlanUpdate && v.Action != PlanDelete {
+			continue
+		}
+		cur, err := GetPost(ctx, store, p.Collection, v.UUID)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrPlanStale, v.UUID, err)
+		}
+		if postHash(schema, cur) != v.Base {
+			return fmt.Errorf("%w: %s", ErrPlanStale, v.UUID)
+		}
+	}
+
+	for _, v := range p.Items {
+		switch v.Action {
+		case PlanCreate:
+			post := v.Post
+			post.SetCreateAt()
+			// 確認後に作成された場合も上書きしない
+			if err := CreateDocument(ctx, store, p.Collection, v.UUID, post); err != nil {
+				return err
+			}
+		case PlanUpdate:
+			// 確認後に変更された場合も上書きしない
+			var cur Post
+			err := UpdateDocument(ctx, store, p.Collection, v.UUID, &cur, func(exists bool) error {
+				if !exists || cur.IsDeleted() || postHash(schema, cur) != v.Base {
+					return fmt.Errorf("%w: %s", ErrPlanStale, v.UUID)
+				}
+				applyColumns(schema, &cur, v.Post, p.Positions)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		case PlanDelete:
+			if err := DeletePost(ctx, store, p.Collection, v.UUID, by); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// WriteText 計画を人が読める形式で書き出します
+// + create, ~ update, - delete
+func (p ImportPlan) WriteText(w io.Writer) error {
+	var b strings.Builder
+	summary := p.Summary()
+	fmt.Fprintf(&b, "plan for %s: %d to create, %d to update, %d to delete, %d unchanged\n",
+		p.AccountID, summary[PlanCreate], summary[PlanUpdate], summary[PlanDelete], summary[PlanNoop])
+
+	for _, v := range p.Items {
+		switch v.Action {
+		case PlanCreate:
+			fmt.Fprintf(&b, "+ %s %q\n", v.UUID, v.Post.Text)
+		case PlanUpdate:
+			fmt.Fprintf(&b, "~ %s\n", v.UUID)
+			for _, c := range v.Changes {
+				fmt.Fprintf(&b, "    %s: %q -> %q\n", c.Field, c.From, c.To)
+			}
+		case PlanDelete:
+			fmt.Fprintf(&b, "- %s\n", v.UUID)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
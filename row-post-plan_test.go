@@ -0,0 +1,56 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportPlanApplyKeepsCurrentFields(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	stored := Post{UUID: "u1", ID: "acct", Text: "before", Priority: 5}
+	require.NoError(t, store.Set(ctx, "posts", stored.UUID, stored))
+
+	positions := PostSchema().Positions([]string{"uuid", "text"})
+	plan, err := Plan(ctx, store, "posts", "acct", positions, []Post{{UUID: "u1", Text: "after"}})
+	require.NoError(t, err)
+	require.Equal(t, 1, plan.Summary()[PlanUpdate])
+
+	// 計画作成後、csvに含まれないフィールドのみ変更された
+	postedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	stored.Status = StatusPublished
+	stored.LastPostedAt = postedAt
+	require.NoError(t, store.Set(ctx, "posts", stored.UUID, stored))
+
+	require.NoError(t, plan.Apply(ctx, store, "test"))
+
+	var got Post
+	require.NoError(t, store.Get(ctx, "posts", "u1", &got))
+	assert.Equal(t, "after", got.Text)
+	assert.Equal(t, 5, got.Priority)
+	assert.Equal(t, StatusPublished, got.Status)
+	assert.True(t, postedAt.Equal(got.LastPostedAt))
+}
+
+func TestImportPlanApplyStale(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	stored := Post{UUID: "u1", ID: "acct", Text: "before"}
+	require.NoError(t, store.Set(ctx, "posts", stored.UUID, stored))
+
+	plan, err := Plan(ctx, store, "posts", "acct", nil, []Post{{UUID: "u1", Text: "after"}})
+	require.NoError(t, err)
+
+	stored.Text = "edited"
+	require.NoError(t, store.Set(ctx, "posts", stored.UUID, stored))
+
+	assert.ErrorIs(t, plan.Apply(ctx, store, "test"), ErrPlanStale)
+
+	var got Post
+	require.NoError(t, store.Get(ctx, "posts", "u1", &got))
+	assert.Equal(t, "edited", got.Text)
+}
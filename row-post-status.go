@@ -0,0 +1,154 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrInvalidTransition = errors.New("invalid post status transition")
+
+// PostStatus is a state of Post approval workflow.
+// draft -> in_review -> approved -> scheduled -> published -> failed/archived
+type PostStatus uint8
+
+const (
+	// StatusUnset is the zero value, Checked, IsDeleteから状態を判定する
+	StatusUnset PostStatus = iota
+	StatusDraft
+	StatusInReview
+	StatusApproved
+	StatusScheduled
+	StatusPublished
+	StatusFailed
+	StatusArchived
+)
+
+func (p PostStatus) String() string {
+	switch p {
+	case StatusDraft:
+		return "draft"
+	case StatusInReview:
+		return "in_review"
+	case StatusApproved:
+		return "approved"
+	case StatusScheduled:
+		return "scheduled"
+	case StatusPublished:
+		return "published"
+	case StatusFailed:
+		return "failed"
+	case StatusArchived:
+		return "archived"
+	}
+	return "unset"
+}
+
+// StatusChange is a record of PostStatus transition.
+type StatusChange struct {
+	From PostStatus `firestore:"from" json:"from"`
+	To   PostStatus `firestore:"to" json:"to"`
+	// By is User.UUID, or system name such as scheduler
+	By string    `firestore:"by,omitempty" json:"by,omitempty"`
+	At time.Time `firestore:"at,omitempty" json:"at,omitempty"`
+}
+
+// transitions 許可された遷移と、遷移に必要なAction
+var transitions = map[PostStatus]map[PostStatus]Action{
+	StatusDraft: {
+		StatusInReview: ActionWrite,
+		StatusArchived: ActionDelete,
+	},
+	StatusInReview: {
+		StatusApproved: ActionApprove,
+		StatusDraft:    ActionApprove, // 差し戻し
+		StatusArchived: ActionDelete,
+	},
+	StatusApproved: {
+		StatusScheduled: ActionWrite,
+		StatusDraft:     ActionWrite, // 承認後の再編集
+		StatusArchived:  ActionDelete,
+	},
+	StatusScheduled: {
+		StatusPublished: ActionWrite,
+		StatusFailed:    ActionWrite,
+		StatusApproved:  ActionWrite, // 予約の取り消し
+		StatusArchived:  ActionDelete,
+	},
+	StatusPublished: {
+		StatusScheduled: ActionWrite, // 繰り返し投稿
+		StatusArchived:  ActionDelete,
+	},
+	StatusFailed: {
+		StatusScheduled: ActionWrite, // 再試行
+		StatusDraft:     ActionWrite,
+		StatusArchived:  ActionDelete,
+	},
+	StatusArchived: {
+		StatusDraft: ActionWrite, // 復元
+	},
+}
+
+// CanTransition 遷移が許可されているか、許可されている場合は必要なActionを返します
+func (p PostStatus) CanTransition(to PostStatus) (Action, bool) {
+	action, ok := transitions[p][to]
+	return action, ok
+}
+
+// CurrentStatus Postの状態を返します
+// Statusが未設定の旧データは、IsDelete, PostURL, Checked, IsScheduleから判定する
+func (p Post) CurrentStatus() PostStatus {
+	if p.Status != StatusUnset {
+		return p.Status
+	}
+
+	switch {
+	case p.IsDelete:
+		return StatusArchived
+	case p.PostURL != "" || !p.LastPostedAt.IsZero():
+		// 投稿済みの旧データもCheckedを持つため、先に判定する
+		return StatusPublished
+	case p.Checked > 0 && p.IsSchedule:
+		return StatusScheduled
+	case p.Checked > 0:
+		return StatusApproved
+	}
+	return StatusDraft
+}
+
+// Transition Postの状態を遷移させ、履歴を記録します
+// roleは実行者のRole、遷移ごとに必要なActionを許可されている必要がある
+func (p *Post) Transition(to PostStatus, by string, role Role, at time.Time) error {
+	from := p.CurrentStatus()
+
+	action, ok := from.CanTransition(to)
+	if !ok {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, to)
+	}
+	if !role.Can(action) {
+		return fmt.Errorf("%w: role %s cannot %s -> %s", ErrForbidden, role, from, to)
+	}
+
+	p.Status = to
+	p.StatusHistory = append(p.StatusHistory, StatusChange{
+		From: from,
+		To:   to,
+		By:   by,
+		At:   at,
+	})
+	p.syncLegacyStatus()
+
+	return nil
+}
+
+// syncLegacyStatus Checkedを状態に合わせて更新します
+// why: Checkedを参照する既存の処理との後方互換のため
+// IsDeleteはゴミ箱(SoftDelete)のフラグとして扱うため、ここでは更新しない
+func (p *Post) syncLegacyStatus() {
+	switch p.Status {
+	case StatusApproved, StatusScheduled, StatusPublished:
+		p.Checked = 1
+	default:
+		p.Checked = 0
+	}
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostCurrentStatus(t *testing.T) {
+	postedAt := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		post Post
+		want PostStatus
+	}{
+		{name: "draft", post: Post{}, want: StatusDraft},
+		{name: "approved", post: Post{Checked: 1}, want: StatusApproved},
+		{name: "scheduled", post: Post{Checked: 1, IsSchedule: true}, want: StatusScheduled},
+		{name: "published with url", post: Post{Checked: 1, PostURL: "https://x.com/i/status/1"}, want: StatusPublished},
+		{name: "published scheduled", post: Post{Checked: 1, IsSchedule: true, LastPostedAt: postedAt}, want: StatusPublished},
+		{name: "deleted", post: Post{Checked: 1, IsDelete: true, PostURL: "https://x.com/i/status/1"}, want: StatusArchived},
+		{name: "status set", post: Post{Checked: 1, PostURL: "https://x.com/i/status/1", Status: StatusFailed}, want: StatusFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.post.CurrentStatus())
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var ErrPostDeleted = errors.New("post is deleted")
+
+// IsDeleted ゴミ箱に入っているかを確認
+// DeletedAtを持たない旧データはIsDeleteで判定する
+func (p Post) IsDeleted() bool {
+	return p.IsDelete || !p.DeletedAt.IsZero()
+}
+
+// SoftDelete ゴミ箱に移動します
+func (p *Post) SoftDelete(by string, at time.Time) {
+	p.IsDelete = true
+	p.DeletedAt = at
+	p.DeletedBy = by
+}
+
+// Restore ゴミ箱から復元します
+func (p *Post) Restore() {
+	p.IsDelete = false
+	p.DeletedAt = time.Time{}
+	p.DeletedBy = ""
+}
+
+// GetPost 投稿を取得します、ゴミ箱の投稿はErrPostDeletedを返す
+func GetPost(ctx context.Context, store Store, colName, postID string) (Post, error) {
+	var post Post
+	if err := store.Get(ctx, colName, postID, &post); err != nil {
+		return Post{}, err
+	}
+	if post.IsDeleted() {
+		return post, fmt.Errorf("%w: %s", ErrPostDeleted, postID)
+	}
+	return post, nil
+}
+
+// ListPosts アカウントの投稿を取得します、ゴミ箱の投稿は含まない
+func ListPosts(ctx context.Context, store Store, colName, accountID string) ([]Post, error) {
+	posts, err := listPosts(ctx, store, colName, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []Post{}
+	for _, v := range posts {
+		if !v.IsDeleted() {
+			list = append(list, v)
+		}
+	}
+	return list, nil
+}
+
+// ListDeletedPosts ゴミ箱の投稿を取得します
+func ListDeletedPosts(ctx context.Context, store Store, colName, accountID string) ([]Post, error) {
+	posts, err := listPosts(ctx, store, colName, accountID, Where("is_delete", true))
+	if err != nil {
+		return nil, err
+	}
+
+	list := []Post{}
+	for _, v := range posts {
+		if v.IsDeleted() {
+			list = append(list, v)
+		}
+	}
+	return list, nil
+}
+
+func listPosts(ctx context.Context, store Store, colName, accountID string, filters ...Filter) ([]Post, error) {
+	if accountID != "" {
+		filters = append(filters, Where("id", accountID))
+	}
+
+	var posts []Post
+	if err := store.List(ctx, colName, filters, &posts); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// errPostUnchanged 既にゴミ箱にある、またはゴミ箱に無い投稿、書き込まない
+var errPostUnchanged = errors.New("post is not changed")
+
+// updatePost 投稿を読み込み、fnで変更して書き込みます
+// 投稿が無い場合はErrNotFound、fnがerrPostUnchangedを返した場合は何もしない
+func updatePost(ctx context.Context, store Store, colName, postID string, fn func(post *Post) error) error {
+	var post Post
+	err := UpdateDocument(ctx, store, colName, postID, &post, func(exists bool) error {
+		if !exists {
+			return fmt.Errorf("%w: %s/%s", ErrNotFound, colName, postID)
+		}
+		return fn(&post)
+	})
+	if errors.Is(err, errPostUnchanged) {
+		return nil
+	}
+	return err
+}
+
+// DeletePost 投稿をゴミ箱に移動します
+func DeletePost(ctx context.Context, store Store, colName, postID, by string) error {
+	return updatePost(ctx, store, colName, postID, func(post *Post) error {
+		if post.IsDeleted() {
+			return errPostUnchanged
+		}
+		post.SoftDelete(by, time.Now())
+		return nil
+	})
+}
+
+// RestorePost ゴミ箱の投稿を復元します
+func RestorePost(ctx context.Context, store Store, colName, postID string) error {
+	return updatePost(ctx, store, colName, postID, func(post *Post) error {
+		if !post.IsDeleted() {
+			return errPostUnchanged
+		}
+		post.Restore()
+		return nil
+	})
+}
+
+// PurgeDeletedPosts ゴミ箱に入ってからretention以上経過した投稿を物理削除します
+// DeletedAtを持たない旧データは、now時点で削除されたものとして記録し、次回以降の対象とする
+// ゴミ箱の投稿のみを1件ずつ読み込む
+func PurgeDeletedPosts(ctx context.Context, store Store, colName string, retention time.Duration, now time.Time) (purged int, err error) {
+	cutoff := now.Add(-retention)
+	err = Each(ctx, store, colName, []Filter{Where("is_delete", true)}, func(v Post) error {
+		if v.DeletedAt.IsZero() {
+			err := updatePost(ctx, store, colName, v.UUID, func(post *Post) error {
+				if !post.IsDeleted() || !post.DeletedAt.IsZero() {
+					return errPostUnchanged
+				}
+				post.DeletedAt = now
+				return nil
+			})
+			if err != nil {
+				log.Error().Err(err).Str("function", "PurgeDeletedPosts").Msgf("error setting deleted_at: %s", v.UUID)
+			}
+			return nil
+		}
+		if v.DeletedAt.After(cutoff) {
+			return nil
+		}
+
+		if err := store.Delete(ctx, colName, v.UUID); err != nil {
+			log.Error().Err(err).Str("function", "PurgeDeletedPosts").Msgf("error purging post: %s", v.UUID)
+			return nil
+		}
+		purged++
+		return nil
+	})
+	return purged, err
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPurgeDeletedPosts(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	now := time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC)
+
+	require.NoError(t, store.Set(ctx, "posts", "live", Post{UUID: "live", ID: "acct"}))
+	require.NoError(t, store.Set(ctx, "posts", "old", Post{UUID: "old", ID: "acct", IsDelete: true, DeletedAt: now.Add(-31 * 24 * time.Hour)}))
+	require.NoError(t, store.Set(ctx, "posts", "new", Post{UUID: "new", ID: "acct", IsDelete: true, DeletedAt: now.Add(-time.Hour)}))
+	require.NoError(t, store.Set(ctx, "posts", "legacy", Post{UUID: "legacy", ID: "acct", IsDelete: true}))
+
+	purged, err := PurgeDeletedPosts(ctx, store, "posts", 30*24*time.Hour, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	var post Post
+	assert.ErrorIs(t, store.Get(ctx, "posts", "old", &post), ErrNotFound)
+	require.NoError(t, store.Get(ctx, "posts", "legacy", &post))
+	assert.True(t, now.Equal(post.DeletedAt))
+
+	deleted, err := ListDeletedPosts(ctx, store, "posts", "acct")
+	require.NoError(t, err)
+	assert.Len(t, deleted, 2)
+}
+
+func TestDeleteRestorePost(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	require.NoError(t, store.Set(ctx, "posts", "u1", Post{UUID: "u1", ID: "acct", Priority: 3}))
+
+	require.NoError(t, DeletePost(ctx, store, "posts", "u1", "test"))
+	_, err := GetPost(ctx, store, "posts", "u1")
+	assert.ErrorIs(t, err, ErrPostDeleted)
+
+	require.NoError(t, RestorePost(ctx, store, "posts", "u1"))
+	post, err := GetPost(ctx, store, "posts", "u1")
+	require.NoError(t, err)
+	assert.Equal(t, 3, post.Priority)
+
+	assert.ErrorIs(t, DeletePost(ctx, store, "posts", "missing", "test"), ErrNotFound)
+}
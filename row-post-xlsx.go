@@ -0,0 +1,207 @@
+package models
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// builtinDateNumFmts Excel組み込みの日付・時刻書式ID
+var builtinDateNumFmts = map[int]bool{
+	14: true, 15: true, 16: true, 17: true, 18: true, 19: true, 20: true, 21: true, 22: true,
+	27: true, 28: true, 29: true, 30: true, 31: true, 32: true, 33: true, 34: true, 35: true, 36: true,
+	45: true, 46: true, 47: true, 50: true, 51: true, 52: true, 53: true, 54: true, 55: true, 56: true, 57: true, 58: true,
+}
+
+// ImportPostsXLSX XLSXの各シートからPostを生成します
+// sheetsを指定した場合は該当シートのみ、未指定の場合は表示中の全シートを対象とする
+func ImportPostsXLSX(r io.Reader, sheets ...string) ([]SheetImport, error) {
+	return ImportPostsXLSXWithSchema(PostSchema(), r, sheets...)
+}
+
+// ImportPostsXLSXWithSchema ヘッダーの別名を追加したSchemaでXLSXからPostを生成します
+func ImportPostsXLSXWithSchema(schema Schema, r io.Reader, sheets ...string) ([]SheetImport, error) {
+	f, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading xlsx: %v", err)
+	}
+	defer f.Close()
+
+	tables, err := readXLSX(f, sheets)
+	if err != nil {
+		return nil, err
+	}
+
+	return importSheets(schema, tables), nil
+}
+
+func readXLSX(f *excelize.File, sheets []string) ([]SheetTable, error) {
+	if len(sheets) == 0 {
+		for _, name := range f.GetSheetList() {
+			if visible, err := f.GetSheetVisible(name); err == nil && !visible {
+				continue
+			}
+			sheets = append(sheets, name)
+		}
+	}
+
+	date1904 := false
+	if props, err := f.GetWorkbookProps(); err == nil && props.Date1904 != nil {
+		date1904 = *props.Date1904
+	}
+
+	tables := make([]SheetTable, 0, len(sheets))
+	for _, name := range sheets {
+		// 書式適用前の値を取得し、日付セルのみ変換する
+		rows, err := f.GetRows(name, excelize.Options{RawCellValue: true})
+		if err != nil {
+			return nil, fmt.Errorf("error reading xlsx sheet %s: %v", name, err)
+		}
+
+		for i, row := range rows {
+			for j, value := range row {
+				if value == "" {
+					continue
+				}
+				axis, err := excelize.CoordinatesToCellName(j+1, i+1)
+				if err != nil {
+					continue
+				}
+				if v, ok := xlsxDateValue(f, name, axis, value, date1904); ok {
+					rows[i][j] = v
+				}
+			}
+		}
+
+		tables = append(tables, SheetTable{Name: name, Rows: rows})
+	}
+
+	return tables, nil
+}
+
+// xlsxDateValue 日付書式のセルであれば、シリアル値を日時文字列に変換します
+func xlsxDateValue(f *excelize.File, sheet, axis, value string, date1904 bool) (string, bool) {
+	serial, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return "", false
+	}
+
+	idx, err := f.GetCellStyle(sheet, axis)
+	if err != nil || idx == 0 {
+		return "", false
+	}
+	style, err := f.GetStyle(idx)
+	if err != nil {
+		return "", false
+	}
+	if !builtinDateNumFmts[style.NumFmt] && (style.CustomNumFmt == nil || !isDateNumFmt(*style.CustomNumFmt)) {
+		return "", false
+	}
+
+	t, err := excelize.ExcelDateToTime(serial, date1904)
+	if err != nil {
+		return "", false
+	}
+	if serial == float64(int64(serial)) {
+		return t.Format("2006-01-02"), true
+	}
+	return t.Format("2006-01-02 15:04:05"), true
+}
+
+// isDateNumFmt ユーザー定義書式が日付・時刻を表すかを確認
+// 引用符、角括弧内の文字は書式記号として扱わない
+func isDateNumFmt(format string) bool {
+	quoted, bracket := false, false
+	for _, r := range strings.ToLower(format) {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+		case r == '[':
+			bracket = true
+		case r == ']':
+			bracket = false
+		case bracket:
+		case strings.ContainsRune("ymdhs", r):
+			return true
+		}
+	}
+	return false
+}
+
+// ExportPostsXLSX PostをXLSXに書き出します
+// 本文はセル内改行を表示するため折り返し表示とする
+func ExportPostsXLSX(w io.Writer, posts []Post, locale Locale, sheet string) error {
+	return ExportPostsXLSXWithSchema(PostSchema(), w, posts, locale, sheet)
+}
+
+// ExportPostsXLSXWithSchema 表示名を変更したSchemaでPostをXLSXに書き出します
+func ExportPostsXLSXWithSchema(schema Schema, w io.Writer, posts []Post, locale Locale, sheet string) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	if sheet == "" {
+		sheet = "posts"
+	}
+	if err := f.SetSheetName(f.GetSheetName(0), sheet); err != nil {
+		return fmt.Errorf("error writing xlsx: %v", err)
+	}
+
+	header := toAnySlice(schema.Header(locale))
+	if err := f.SetSheetRow(sheet, "A1", &header); err != nil {
+		return fmt.Errorf("error writing xlsx: %v", err)
+	}
+	for i, post := range posts {
+		axis, _ := excelize.CoordinatesToCellName(1, i+2)
+		values := encodePostValues(schema, post)
+		if err := f.SetSheetRow(sheet, axis, &values); err != nil {
+			return fmt.Errorf("error writing xlsx: %v", err)
+		}
+	}
+
+	style, err := f.NewStyle(&excelize.Style{Alignment: &excelize.Alignment{WrapText: true, Vertical: "top"}})
+	if err != nil {
+		return fmt.Errorf("error writing xlsx: %v", err)
+	}
+	for i, c := range schema.Columns {
+		if c.Name != "text" {
+			continue
+		}
+		col, _ := excelize.ColumnNumberToName(i + 1)
+		if err := f.SetColStyle(sheet, col, style); err != nil {
+			return fmt.Errorf("error writing xlsx: %v", err)
+		}
+	}
+
+	if err := f.Write(w); err != nil {
+		return fmt.Errorf("error writing xlsx: %v", err)
+	}
+	return nil
+}
+
+func toAnySlice(s []string) []any {
+	values := make([]any, len(s))
+	for i, v := range s {
+		values[i] = v
+	}
+	return values
+}
+
+// encodePostValues 数値カラムを数値のまま返します
+func encodePostValues(schema Schema, post Post) []any {
+	row := encodePostRow(schema, post)
+	values := toAnySlice(row)
+	for i, c := range schema.Columns {
+		if c.kind != reflect.Int {
+			continue
+		}
+		if n, err := strconv.Atoi(row[i]); err == nil {
+			values[i] = n
+		}
+	}
+	return values
+}
@@ -27,15 +27,26 @@ type Post struct {
 	IsSchedule bool `csv:"is_schedule" dataframe:"is_schedule" firestore:"is_schedule" json:"is_schedule,omitempty"`
 
 	// 以下は、csv, dataframeには含まれない
-	IsDelete     bool      `csv:"-" dataframe:"-" firestore:"is_delete" json:"-,omitempty"`
-	LastPostedAt time.Time `csv:"-" dataframe:"-" firestore:"last_posted_at,omitempty" json:"last_posted_at,omitempty"`
-	CreatedAt    time.Time `csv:"-" dataframe:"-" firestore:"created_at,omitempty" json:"created_at,omitempty"`
+	IsDelete  bool      `csv:"-" dataframe:"-" firestore:"is_delete" json:"-,omitempty"`
+	DeletedAt time.Time `csv:"-" dataframe:"-" firestore:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy string    `csv:"-" dataframe:"-" firestore:"deleted_by,omitempty" json:"deleted_by,omitempty"`
+	// Status is approval workflow state, see CurrentStatus
+	Status        PostStatus     `csv:"-" dataframe:"-" firestore:"status,omitempty" json:"status,omitempty"`
+	StatusHistory []StatusChange `csv:"-" dataframe:"-" firestore:"status_history,omitempty" json:"status_history,omitempty"`
+	LastPostedAt  time.Time      `csv:"-" dataframe:"-" firestore:"last_posted_at,omitempty" json:"last_posted_at,omitempty"`
+	CreatedAt     time.Time      `csv:"-" dataframe:"-" firestore:"created_at,omitempty" json:"created_at,omitempty"`
 }
 
 func (p Post) GetID() string {
 	return p.ID
 }
 
+// GetOwnerID for interface
+// Postの所有者はTwitter/X AccountID
+func (p Post) GetOwnerID() string {
+	return p.ID
+}
+
 func (p *Post) SetLastPostedAt() bool {
 	if p.LastPostedAt.IsZero() {
 		p.LastPostedAt = time.Now()
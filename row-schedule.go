@@ -53,6 +53,15 @@ type Schedule struct {
 
 	// Times is setting multiple times.
 	Times []time.Time `csv:"-" dataframe:"times" firestore:"times,omitempty" json:"times,omitempty"`
+
+	// DeferredUntil is the next slot after X API rate limit, see IsDue.
+	// 延期した投稿、投稿後はゼロ値
+	DeferredUntil time.Time `csv:"-" dataframe:"-" firestore:"deferred_until,omitempty" json:"deferred_until,omitempty"`
+}
+
+// GetOwnerID for interface
+func (s Schedule) GetOwnerID() string {
+	return s.OwnerId
 }
 
 // IsScheduleToday is a function to determine if the schedule is today.
@@ -6,6 +6,9 @@ import "time"
 type Rule struct {
 	UUID string `csv:"-" dataframe:"uuid" firestore:"uuid,omitempty" json:"uuid,omitempty"`
 
+	// OwnerId is Twitter/X AccountID
+	OwnerId string `csv:"-" dataframe:"owner_id" firestore:"owner_id,omitempty" json:"owner_id,omitempty"`
+
 	// IsDisenable is a flag for enable posting.
 	// why: 一時的な投稿停止を行うためのフラグ。投稿停止中は投稿を行わない
 	IsDisenable bool `csv:"-" dataframe:"is_disenable" firestore:"is_disenable,omitempty" json:"is_disenable,omitempty"`
@@ -25,3 +28,8 @@ type Rule struct {
 	// Times is setting multiple times. Rule.Times > Schedule.Times
 	Times []time.Time `csv:"-" dataframe:"times" firestore:"times,omitempty" json:"times,omitempty"`
 }
+
+// GetOwnerID for interface
+func (p Rule) GetOwnerID() string {
+	return p.OwnerId
+}
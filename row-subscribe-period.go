@@ -0,0 +1,139 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// GracePeriod is how long a past_due subscription keeps its plan.
+var GracePeriod = 7 * 24 * time.Hour
+
+// RenewalGrace is how long a plan is kept after PeriodEnd until the renewal event arrives.
+// 更新の請求は期間終了後に確定するため、Webhookが届くまでダウングレードしない
+var RenewalGrace = 2 * time.Hour
+
+// Values of Subscribe.BillingStatus set by periods.
+const (
+	SubscribeTrialing = "trialing"
+	SubscribeActive   = "active"
+	SubscribeExpired  = "expired"
+)
+
+// StartTrial 試用期間を開始します、試用期間中は指定したプランの使用制限を適用する
+func (s *Subscribe) StartTrial(catalogs *PlanCatalogs, plan SubscribedPlan, d time.Duration, at time.Time) *Subscribe {
+	s.SetWithCatalog(catalogs, plan, at)
+	s.StartedAt = at
+	s.TrialEnd = at.Add(d)
+	s.GraceEnd = time.Time{}
+	s.BillingStatus = SubscribeTrialing
+	return s
+}
+
+// Renew 購読期間を更新します、支払い遅延の猶予期間は解除する
+func (s *Subscribe) Renew(start, end time.Time) *Subscribe {
+	if s.StartedAt.IsZero() {
+		s.StartedAt = start
+	}
+	s.PeriodStart, s.PeriodEnd = start, end
+	s.GraceEnd = time.Time{}
+	s.BillingStatus = SubscribeActive
+	return s
+}
+
+// MarkPastDue 支払い遅延として猶予期間を開始します
+// 既に猶予期間中の場合は延長しない
+func (s *Subscribe) MarkPastDue(at time.Time) *Subscribe {
+	if s.GraceEnd.IsZero() {
+		s.GraceEnd = at.Add(GracePeriod)
+	}
+	s.BillingStatus = string(BillingPastDue)
+	return s
+}
+
+// ExpiresAt プランの期限を返します、ゼロ値は無期限
+// 支払い遅延中は猶予期間の終了、それ以外は試用期間と購読期間の遅い方
+func (s Subscribe) ExpiresAt() time.Time {
+	if s.BillingStatus == string(BillingPastDue) && !s.GraceEnd.IsZero() {
+		return s.GraceEnd
+	}
+	if s.TrialEnd.After(s.PeriodEnd) {
+		return s.TrialEnd
+	}
+	return s.PeriodEnd
+}
+
+// EffectivePlan 指定日時に有効なプランを返します
+// 期限を過ぎている場合はDowngradeTo、支払い遅延中以外は期限からRenewalGraceの間はプランを維持する
+func (s Subscribe) EffectivePlan(at time.Time) SubscribedPlan {
+	if s.Plan == Unsubscribed {
+		return Unsubscribed
+	}
+	exp := s.ExpiresAt()
+	if !exp.IsZero() && s.BillingStatus != string(BillingPastDue) {
+		exp = exp.Add(RenewalGrace)
+	}
+	if !exp.IsZero() && !at.Before(exp) {
+		return s.DowngradeTo
+	}
+	return s.Plan
+}
+
+// Expire 期限を過ぎている場合、DowngradeToのプランに変更します
+// 変更した場合はtrueを返す
+func (s *Subscribe) Expire(catalogs *PlanCatalogs, at time.Time) bool {
+	plan := s.EffectivePlan(at)
+	if plan == s.Plan {
+		return false
+	}
+
+	if _, ok := catalogs.Current(at).Plan(plan); ok {
+		s.SetWithCatalog(catalogs, plan, at)
+	} else {
+		s.Plan = plan
+		s.applyLimits(PlanDefinition{})
+	}
+	s.TrialEnd = time.Time{}
+	s.GraceEnd = time.Time{}
+	s.PeriodStart, s.PeriodEnd = time.Time{}, time.Time{}
+	s.BillingStatus = SubscribeExpired
+	return true
+}
+
+// effective 指定日時に有効なプランの使用制限を適用したコピーを返します
+func (s *Subscribe) effective(catalogs *PlanCatalogs, at time.Time) *Subscribe {
+	if s.EffectivePlan(at) == s.Plan {
+		return s
+	}
+
+	c := *s
+	c.Channels = make(map[string]Managed, len(s.Channels))
+	for k, v := range s.Channels {
+		c.Channels[k] = v
+	}
+	c.Expire(catalogs, at)
+	return &c
+}
+
+// ExpireSubscribes 期限を過ぎた購読を一括でダウングレードします
+// 保存に失敗した購読はログを出力して続行する
+func ExpireSubscribes(ctx context.Context, store Store, colName string, catalogs *PlanCatalogs, now time.Time) (expired int, err error) {
+	var subscribes []Subscribe
+	if err := store.List(ctx, colName, nil, &subscribes); err != nil {
+		return 0, err
+	}
+
+	for _, v := range subscribes {
+		if !v.Expire(catalogs, now) {
+			continue
+		}
+		if err := store.Set(ctx, colName, v.ID, v); err != nil {
+			log.Error().Err(err).Str("function", "ExpireSubscribes").Msgf("error setting subscribe: %s", v.ID)
+			continue
+		}
+		expired++
+	}
+
+	return expired, nil
+}
@@ -1,9 +1,16 @@
 package models
 
 import (
+	"errors"
+	"fmt"
 	"time"
 )
 
+var (
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	ErrQuotaChannel  = errors.New("quota channel is not in the plan")
+)
+
 // Subscribe is used to manage the subscription status of the account
 // アカウントの購読状況を管理するために使用されます
 type Subscribe struct {
@@ -12,17 +19,68 @@ type Subscribe struct {
 	// Plan is SubscribedPlan
 	Plan SubscribedPlan `firestore:"plan,omitempty" json:"plan,omitempty"`
 
+	// Channels is usage and limits by channel name, e.g. ChannelGUI, ChannelMedia.
+	Channels map[string]Managed `firestore:"channels,omitempty" json:"channels,omitempty"`
+
+	// Deprecated: ManagedGUI, ManagedAPI are kept for documents before Channels.
+	// 読み込み時にChannelsに無い場合のみ使用し、gui, apiの更新時は既存の処理のため同じ値を書き込む
 	ManagedGUI Managed `firestore:"managed_gui,omitempty" json:"managed_gui,omitempty"`
 	ManagedAPI Managed `firestore:"managed_api,omitempty" json:"managed_api,omitempty"`
+
+	// CatalogVersion is a version of PlanCatalog applied, 0 is before the catalog.
+	CatalogVersion int `firestore:"catalog_version,omitempty" json:"catalog_version,omitempty"`
+
+	// CustomerID, SubscriptionID are IDs of billing provider.
+	CustomerID     string `firestore:"customer_id,omitempty" json:"customer_id,omitempty"`
+	SubscriptionID string `firestore:"subscription_id,omitempty" json:"subscription_id,omitempty"`
+	// BillingStatus is trialing, active, past_due, canceled or expired, empty is not billed.
+	BillingStatus string `firestore:"billing_status,omitempty" json:"billing_status,omitempty"`
+	// BillingUpdatedAt is a time of the last applied billing event, older events are ignored.
+	BillingUpdatedAt time.Time `firestore:"billing_updated_at,omitempty" json:"billing_updated_at,omitempty"`
+	// PeriodStart, PeriodEnd is the current billing period.
+	PeriodStart time.Time `firestore:"period_start,omitempty" json:"period_start,omitempty"`
+	PeriodEnd   time.Time `firestore:"period_end,omitempty" json:"period_end,omitempty"`
+
+	StartedAt time.Time `firestore:"started_at,omitempty" json:"started_at,omitempty"`
+	TrialEnd  time.Time `firestore:"trial_end,omitempty" json:"trial_end,omitempty"`
+	// GraceEnd is the end of grace period after payment failure.
+	GraceEnd time.Time `firestore:"grace_end,omitempty" json:"grace_end,omitempty"`
+	// DowngradeTo is a plan after expiry, SubscribedFree or Unsubscribed.
+	DowngradeTo SubscribedPlan `firestore:"downgrade_to,omitempty" json:"downgrade_to,omitempty"`
+
+	clock    Clock
+	catalogs *PlanCatalogs
 }
 
 type Managed struct {
 	Limit Count `firestore:"limit,omitempty" json:"limit,omitempty"`
 	Used  Count `firestore:"used,omitempty" json:"used,omitempty"`
 
+	// Strategy is a name of QuotaStrategy, empty is StrategyCalendar.
+	Strategy string `firestore:"strategy,omitempty" json:"strategy,omitempty"`
+	// Previous is usage of the previous window, for StrategySlidingCounter.
+	Previous Count `firestore:"previous,omitempty" json:"previous,omitempty"`
+	// Log is usage records in the last 30 days, for StrategySlidingLog.
+	Log []QuotaEntry `firestore:"log,omitempty" json:"log,omitempty"`
+	// Tokens is remaining tokens, for StrategyTokenBucket.
+	Tokens Tokens `firestore:"tokens,omitempty" json:"tokens,omitempty"`
+
 	LastUsedAt time.Time `firestore:"last_used_at,omitempty" json:"last_used_at,omitempty"`
 }
 
+// QuotaEntry is a usage record of StrategySlidingLog.
+type QuotaEntry struct {
+	At time.Time `firestore:"at" json:"at"`
+	N  uint16    `firestore:"n" json:"n"`
+}
+
+// Tokens is remaining tokens per window of StrategyTokenBucket.
+type Tokens struct {
+	Monthly float64 `firestore:"monthly,omitempty" json:"monthly,omitempty"`
+	Daily   float64 `firestore:"daily,omitempty" json:"daily,omitempty"`
+	Hourly  float64 `firestore:"hourly,omitempty" json:"hourly,omitempty"`
+}
+
 type Count struct {
 	Monthly uint16 `firestore:"monthly,omitempty" json:"monthly,omitempty"`
 	Daily   uint16 `firestore:"daily,omitempty" json:"daily,omitempty"`
@@ -63,126 +121,182 @@ func NewSubscribe() *Subscribe {
 
 // Set is used to set the usage limit according to the subscribed plan
 // 購読プランに応じて使用制限を設定するために使用されます
+// 制限値はDefaultPlanCatalogsから取得する
 func (s *Subscribe) Set(level SubscribedPlan) *Subscribe {
+	return s.SetWithCatalog(DefaultPlanCatalogs(), level, time.Now())
+}
+
+// SetWithCatalog カタログから購読プランの使用制限を設定します
+// 同じプランの継続では契約時のカタログのバージョンを維持し、プラン変更時は指定日時に有効なカタログを適用する
+// カタログに無いプラン(Unsubscribed)の使用制限は変更しない
+func (s *Subscribe) SetWithCatalog(catalogs *PlanCatalogs, level SubscribedPlan, at time.Time) *Subscribe {
+	catalog := catalogs.Resolve(*s, level, at)
 	s.Plan = level
+	s.CatalogVersion = catalog.Version
+
+	def, ok := catalog.Plan(level)
+	if !ok {
+		return s
+	}
+	s.applyLimits(def)
+
+	return s
+}
+
+// Migrate 据え置きを解除し、指定日時に有効なカタログの使用制限を適用します
+func (s *Subscribe) Migrate(catalogs *PlanCatalogs, at time.Time) *Subscribe {
+	catalog := catalogs.Current(at)
+	s.CatalogVersion = catalog.Version
+
+	if def, ok := catalog.Plan(s.Plan); ok {
+		s.applyLimits(def)
+	}
+	return s
+}
+
+// applyLimits プランの全チャンネルの使用制限を設定します
+// プランに無いチャンネルの使用制限は0とする
+func (s *Subscribe) applyLimits(def PlanDefinition) {
+	names := []string{ChannelGUI, ChannelAPI}
+	for name := range s.Channels {
+		names = append(names, name)
+	}
+	for name := range def.Limits {
+		names = append(names, name)
+	}
+
+	for _, name := range names {
+		m := s.Channel(name)
+		m.Limit = def.Limits[name]
+		s.setChannel(name, m)
+	}
+}
+
+// WithClock 使用回数の記録、確認に使用する時刻を差し替えます
+func (s *Subscribe) WithClock(clock Clock) *Subscribe {
+	s.clock = clock
+	return s
+}
 
-	switch level {
-	case SubscribedFree:
-		s.ManagedGUI.Limit.Monthly = 0
-		s.ManagedGUI.Limit.Daily = 0
-		s.ManagedGUI.Limit.Hourly = 0
+// WithCatalogs 期限切れ後の使用制限の計算に使用するカタログを差し替えます
+func (s *Subscribe) WithCatalogs(catalogs *PlanCatalogs) *Subscribe {
+	s.catalogs = catalogs
+	return s
+}
 
-		s.ManagedAPI.Limit.Monthly = 50
-		s.ManagedAPI.Limit.Daily = 3
-		s.ManagedAPI.Limit.Hourly = 1
+// planCatalogs 未設定の場合はDefaultPlanCatalogs
+func (s *Subscribe) planCatalogs() *PlanCatalogs {
+	if s.catalogs == nil {
+		return DefaultPlanCatalogs()
+	}
+	return s.catalogs
+}
 
-	case SubscribedBasic:
-		s.ManagedGUI.Limit.Monthly = 30
-		s.ManagedGUI.Limit.Daily = 1
-		s.ManagedGUI.Limit.Hourly = 1
+func (s *Subscribe) now() time.Time {
+	if s.clock == nil {
+		return SystemClock.Now()
+	}
+	return s.clock.Now()
+}
 
-		s.ManagedAPI.Limit.Monthly = 10000
-		s.ManagedAPI.Limit.Daily = 1000
-		s.ManagedAPI.Limit.Hourly = 100
+// Channel チャンネルの使用状況を返します
+// Channelsに無いgui, apiは旧来のManagedGUI, ManagedAPIを返す
+func (s *Subscribe) Channel(name string) Managed {
+	if m, ok := s.Channels[name]; ok {
+		return m
+	}
+	switch name {
+	case ChannelGUI:
+		return s.ManagedGUI
+	case ChannelAPI:
+		return s.ManagedAPI
+	}
+	return Managed{}
+}
 
-	case SubscribedPro:
-		s.ManagedGUI.Limit.Monthly = 1000
-		s.ManagedGUI.Limit.Daily = 100
-		s.ManagedGUI.Limit.Hourly = 10
+// HasChannel チャンネルが購読プランに含まれるかを返します
+func (s *Subscribe) HasChannel(name string) bool {
+	_, ok := s.Channels[name]
+	return ok || name == ChannelGUI || name == ChannelAPI
+}
 
-		s.ManagedAPI.Limit.Monthly = 10000
-		s.ManagedAPI.Limit.Daily = 1000
-		s.ManagedAPI.Limit.Hourly = 100
+func (s *Subscribe) setChannel(name string, m Managed) {
+	if s.Channels == nil {
+		s.Channels = make(map[string]Managed)
+	}
+	s.Channels[name] = m
 
+	switch name {
+	case ChannelGUI:
+		s.ManagedGUI = m
+	case ChannelAPI:
+		s.ManagedAPI = m
 	}
+}
 
+// SetLimit チャンネルの使用制限を設定します、カタログを使用しない場合
+func (s *Subscribe) SetLimit(name string, limit Count) *Subscribe {
+	m := s.Channel(name)
+	m.Limit = limit
+	s.setChannel(name, m)
 	return s
 }
 
+// Consume チャンネルの使用回数をn回分記録します
+// 使用回数 + n が使用制限を超える場合はQuotaErrorを返し、記録しない
+func (s *Subscribe) Consume(name string, n uint16) error {
+	if !s.HasChannel(name) {
+		return fmt.Errorf("%w: %s", ErrQuotaChannel, name)
+	}
+
+	if !s.Allow(name, n) {
+		return &QuotaError{Status: s.Status(name)}
+	}
+
+	m := s.Channel(name)
+	QuotaStrategyOf(m.Strategy).Consume(&m, n, s.now())
+	s.setChannel(name, m)
+	return nil
+}
+
+// Allow チャンネルでn回分を使用できるかを返します
+// 期限を過ぎている場合はダウングレード後の使用制限で確認する、プランに無いチャンネルは使用できない
+func (s *Subscribe) Allow(name string, n uint16) bool {
+	now := s.now()
+	e := s.effective(s.planCatalogs(), now)
+	if !e.HasChannel(name) {
+		return false
+	}
+	m := e.Channel(name)
+	return QuotaStrategyOf(m.Strategy).Allow(m, n, now)
+}
+
+// Exceeded チャンネルの使用制限に達しているか(これ以上使用できないか)を返します
+func (s *Subscribe) Exceeded(name string) bool {
+	return !s.Allow(name, 1)
+}
+
+func channelName(isAPI bool) string {
+	if isAPI {
+		return ChannelAPI
+	}
+	return ChannelGUI
+}
+
 // Increment is used to increment the usage count
 // 使用回数をインクリメントするために使用されます
-// 月、日、時の比較を行い、使用回数をリセットし、最終使用日時を更新します
+// Managed.Strategyの方式で期間ごとの使用回数をリセットし、最終使用日時を更新します
+// 使用制限を超えていても記録する、新しい処理ではConsumeを使用すること
 func (s *Subscribe) Increment(isAPI bool) {
-	now := time.Now()
-	if isAPI {
-		// 最終使用時間が0でない場合(as 使用している場合)、月、日、時の使用回数をリセット
-		if !s.ManagedAPI.LastUsedAt.IsZero() {
-			// 月が変わった場合、月の使用回数をリセットします。
-			// 日が変わった場合、または月が変わった場合、日の使用回数をリセットします。
-			// 時間が変わった場合、日が変わった場合、または月が変わった場合、時間の使用回数をリセットします。
-			// これにより、月が変わった場合でも、日と時間の使用回数が確実にリセットされます。
-			if now.Month() != s.ManagedAPI.LastUsedAt.Month() {
-				s.ManagedAPI.Used.Monthly = 0
-			}
-			if now.Day() != s.ManagedAPI.LastUsedAt.Day() ||
-				now.Month() != s.ManagedAPI.LastUsedAt.Month() {
-				s.ManagedAPI.Used.Daily = 0
-			}
-			if now.Hour() != s.ManagedAPI.LastUsedAt.Hour() ||
-				now.Day() != s.ManagedAPI.LastUsedAt.Day() ||
-				now.Month() != s.ManagedAPI.LastUsedAt.Month() {
-				s.ManagedAPI.Used.Hourly = 0
-			}
-		}
-
-		// 使用回数をインクリメントし、最終使用日時を更新
-		s.ManagedAPI.Used.Monthly++
-		s.ManagedAPI.Used.Daily++
-		s.ManagedAPI.Used.Hourly++
-		s.ManagedAPI.LastUsedAt = now
-
-	} else { // GUI
-		// 最終使用時間が0でない場合(as 使用している場合)、月、日、時の使用回数をリセット
-		if !s.ManagedGUI.LastUsedAt.IsZero() {
-			// 月が変わった場合、月の使用回数をリセットします。
-			// 日が変わった場合、または月が変わった場合、日の使用回数をリセットします。
-			// 時間が変わった場合、日が変わった場合、または月が変わった場合、時間の使用回数をリセットします。
-			// これにより、月が変わった場合でも、日と時間の使用回数が確実にリセットされます。
-			if now.Month() != s.ManagedGUI.LastUsedAt.Month() {
-				s.ManagedGUI.Used.Monthly = 0
-			}
-			if now.Day() != s.ManagedGUI.LastUsedAt.Day() ||
-				now.Month() != s.ManagedGUI.LastUsedAt.Month() {
-				s.ManagedGUI.Used.Daily = 0
-			}
-			if now.Hour() != s.ManagedGUI.LastUsedAt.Hour() ||
-				now.Day() != s.ManagedGUI.LastUsedAt.Day() ||
-				now.Month() != s.ManagedGUI.LastUsedAt.Month() {
-				s.ManagedGUI.Used.Hourly = 0
-			}
-		}
-
-		// 使用回数をインクリメントし、最終使用日時を更新
-		s.ManagedGUI.Used.Monthly++
-		s.ManagedGUI.Used.Daily++
-		s.ManagedGUI.Used.Hourly++
-		s.ManagedGUI.LastUsedAt = now
-
-	}
+	name := channelName(isAPI)
+	m := s.Channel(name)
+	QuotaStrategyOf(m.Strategy).Consume(&m, 1, s.now())
+	s.setChannel(name, m)
 }
 
 // IsLimit is used to check if the usage limit has been reached
 // 使用制限に達したかどうかを確認するために使用されます
+// 使用回数 + 1 が使用制限を超える場合にtrue、使用制限が0の場合は常にtrue
 func (s *Subscribe) IsLimit(isAPI bool) bool {
-	if isAPI {
-		if s.ManagedAPI.Used.Monthly > s.ManagedAPI.Limit.Monthly {
-			return true
-		} else if s.ManagedAPI.Used.Daily > s.ManagedAPI.Limit.Daily {
-			return true
-		} else if s.ManagedAPI.Used.Hourly > s.ManagedAPI.Limit.Hourly {
-			return true
-		}
-
-	} else {
-		if s.ManagedGUI.Used.Monthly > s.ManagedGUI.Limit.Monthly {
-			return true
-		} else if s.ManagedGUI.Used.Daily > s.ManagedGUI.Limit.Daily {
-			return true
-		} else if s.ManagedGUI.Used.Hourly > s.ManagedGUI.Limit.Hourly {
-			return true
-		}
-
-	}
-
-	return false
+	return s.Exceeded(channelName(isAPI))
 }
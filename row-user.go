@@ -0,0 +1,198 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrLinkedAccountLimit    = errors.New("linked account limit reached")
+	ErrLinkedAccountExist    = errors.New("x_id is already linked")
+	ErrLinkedAccountNotFound = errors.New("linked account not found")
+)
+
+// User is a customer who logs in and owns multiple X accounts.
+// 顧客(ログインユーザー)を表し、複数のTwitter/Xアカウントを管理する
+// why: Accountは顧客とXアカウントが1対1で結びついていたため、Proプランで複数アカウントを扱えなかった
+type User struct {
+	UUID     string `csv:"uuid" dataframe:"uuid" firestore:"uuid,omitempty" json:"uuid,omitempty"`
+	Password string `csv:"password" dataframe:"password" firestore:"password,omitempty" json:"password,omitempty" export:"-"`
+	Tel      string `csv:"tel" dataframe:"tel" firestore:"tel,omitempty" json:"tel,omitempty" export:"mask"`
+
+	// Accounts is linked X accounts.
+	Accounts []LinkedAccount `csv:"-" dataframe:"-" firestore:"accounts,omitempty" json:"accounts,omitempty"`
+
+	CreatedAt time.Time `csv:"created_at" dataframe:"created_at" firestore:"created_at,omitempty" json:"created_at,omitempty"`
+}
+
+// LinkedAccount is X account credentials owned by User.
+type LinkedAccount struct {
+	// ID is Twitter/X AccountID
+	ID           string `firestore:"id,omitempty" json:"id,omitempty"`
+	SpreadID     string `firestore:"spread_id,omitempty" json:"spread_id,omitempty"`
+	AccessToken  string `firestore:"access_token,omitempty" json:"access_token,omitempty" export:"-"`
+	AccessSecret string `firestore:"access_secret,omitempty" json:"access_secret,omitempty" export:"-"`
+
+	LinkedAt time.Time `firestore:"linked_at,omitempty" json:"linked_at,omitempty"`
+}
+
+// NewUser 初回会員登録用
+func NewUser(password, tel string) *User {
+	return &User{
+		UUID:     uuid.New().String(),
+		Password: password,
+		Tel:      tel,
+
+		CreatedAt: time.Now(),
+	}
+}
+
+// GetID for interface
+func (p User) GetID() string {
+	return p.UUID
+}
+
+// MaxLinkedAccounts 購読プランごとに連携できるXアカウント数を返します
+func MaxLinkedAccounts(plan SubscribedPlan) int {
+	switch plan {
+	case SubscribedFree:
+		return 1
+	case SubscribedBasic:
+		return 3
+	case SubscribedPro:
+		return 10
+	}
+
+	// Unsubscribedでも既存の1アカウントは保持できる
+	return 1
+}
+
+// Link Xアカウントを連携します
+// 同じXアカウントの重複連携、プランの上限を超える連携はエラーを返す
+func (p *User) Link(account LinkedAccount, plan SubscribedPlan) error {
+	if _, ok := p.Linked(account.ID); ok {
+		return fmt.Errorf("%w: %s", ErrLinkedAccountExist, account.ID)
+	}
+
+	if max := MaxLinkedAccounts(plan); len(p.Accounts) >= max {
+		return fmt.Errorf("%w: plan allows %d accounts", ErrLinkedAccountLimit, max)
+	}
+
+	if account.LinkedAt.IsZero() {
+		account.LinkedAt = time.Now()
+	}
+	p.Accounts = append(p.Accounts, account)
+
+	return nil
+}
+
+// Unlink Xアカウントの連携を解除します
+func (p *User) Unlink(id string) error {
+	for i, v := range p.Accounts {
+		if v.ID == id {
+			p.Accounts = append(p.Accounts[:i], p.Accounts[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrLinkedAccountNotFound, id)
+}
+
+// Linked 連携済みのXアカウントを取得します
+func (p User) Linked(id string) (LinkedAccount, bool) {
+	for _, v := range p.Accounts {
+		if v.ID == id {
+			return v, true
+		}
+	}
+	return LinkedAccount{}, false
+}
+
+// AccountIDs 連携済みのXアカウントIDを返します
+func (p User) AccountIDs() []string {
+	return GetUniqueKeys(p.Accounts, func(v LinkedAccount) string {
+		return v.ID
+	})
+}
+
+// ToAccounts 旧来のAccount形式に展開します
+// why: Accountを前提とした既存の処理を段階的に移行するため
+func (p User) ToAccounts() []Account {
+	accounts := make([]Account, len(p.Accounts))
+	for i, v := range p.Accounts {
+		accounts[i] = Account{
+			UUID:         p.UUID,
+			ID:           v.ID,
+			Password:     p.Password,
+			Tel:          p.Tel,
+			SpreadID:     v.SpreadID,
+			AccessToken:  v.AccessToken,
+			AccessSecret: v.AccessSecret,
+
+			CreatedAt: v.LinkedAt,
+		}
+	}
+	return accounts
+}
+
+// MigrateAccounts 旧来のAccountドキュメントをUserへ移行します
+// 同一UUIDのAccountは1つのUserにまとめ、プラン上限は適用しない
+func MigrateAccounts(accounts []Account) []User {
+	users := []User{}
+	index := make(map[string]int)
+
+	for _, v := range accounts {
+		key := v.UUID
+		if key == "" {
+			key = uuid.New().String()
+		}
+
+		i, ok := index[key]
+		if !ok {
+			users = append(users, User{
+				UUID:      key,
+				Password:  v.Password,
+				Tel:       v.Tel,
+				CreatedAt: v.CreatedAt,
+			})
+			i = len(users) - 1
+			index[key] = i
+		}
+
+		if _, exist := users[i].Linked(v.ID); exist {
+			continue
+		}
+		users[i].Accounts = append(users[i].Accounts, LinkedAccount{
+			ID:           v.ID,
+			SpreadID:     v.SpreadID,
+			AccessToken:  v.AccessToken,
+			AccessSecret: v.AccessSecret,
+			LinkedAt:     v.CreatedAt,
+		})
+	}
+
+	return users
+}
+
+// CheckDupLinkedAccount 他のUserに連携済みのXアカウント、SpreadIDがないかを確認
+// 同一User内でのSpreadIDの共有は許容する
+func CheckDupLinkedAccount(userUUID, id, spreadID string, users []User) error {
+	for _, user := range users {
+		for _, row := range user.Accounts {
+			// UserIDが重複していないかを確認
+			if row.ID == id {
+				return fmt.Errorf("%w: %s", ErrLinkedAccountExist, id)
+			}
+
+			// SpreadIDが他のUserで使われていないかを確認
+			if user.UUID != userUUID && spreadID != "" && row.SpreadID == spreadID {
+				return errors.New("spread id is already exist")
+			}
+		}
+	}
+
+	return nil
+}
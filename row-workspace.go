@@ -0,0 +1,241 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrForbidden       = errors.New("forbidden")
+	ErrNotMember       = errors.New("user is not a member of workspace")
+	ErrOwnerNotInScope = errors.New("owner is not in workspace")
+	ErrLastOwner       = errors.New("workspace must have at least one owner")
+)
+
+// Role is a member role in Workspace.
+type Role uint8
+
+const (
+	RoleNone     Role = iota
+	RoleViewer        // 閲覧のみ
+	RoleApprover      // 閲覧、投稿の承認
+	RoleEditor        // 閲覧、投稿の作成・編集・削除
+	RoleOwner         // 全権限、メンバー管理
+)
+
+func (p Role) String() string {
+	switch p {
+	case RoleViewer:
+		return "viewer"
+	case RoleApprover:
+		return "approver"
+	case RoleEditor:
+		return "editor"
+	case RoleOwner:
+		return "owner"
+	}
+	return "none"
+}
+
+// ParseRole 文字列からRoleを返します
+func ParseRole(s string) (Role, error) {
+	for _, v := range []Role{RoleViewer, RoleApprover, RoleEditor, RoleOwner} {
+		if v.String() == s {
+			return v, nil
+		}
+	}
+	return RoleNone, fmt.Errorf("unknown role: %s", s)
+}
+
+// Action is an operation against a resource.
+type Action uint8
+
+const (
+	ActionRead Action = iota
+	ActionWrite
+	ActionDelete
+	ActionApprove
+	ActionManageMembers
+)
+
+func (p Action) String() string {
+	switch p {
+	case ActionRead:
+		return "read"
+	case ActionWrite:
+		return "write"
+	case ActionDelete:
+		return "delete"
+	case ActionApprove:
+		return "approve"
+	case ActionManageMembers:
+		return "manage_members"
+	}
+	return "unknown"
+}
+
+// ActionFromMethod HTTPメソッドからActionを返します
+// 承認、メンバー管理はメソッドから判別できないため、呼び出し側で指定する
+func ActionFromMethod(method string) Action {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return ActionRead
+	case http.MethodDelete:
+		return ActionDelete
+	}
+	return ActionWrite
+}
+
+// permissions Roleごとに許可されたAction
+var permissions = map[Role][]Action{
+	RoleViewer:   {ActionRead},
+	RoleApprover: {ActionRead, ActionApprove},
+	RoleEditor:   {ActionRead, ActionWrite, ActionDelete},
+	RoleOwner:    {ActionRead, ActionWrite, ActionDelete, ActionApprove, ActionManageMembers},
+}
+
+// Can Roleが指定のActionを許可されているかを確認
+func (p Role) Can(action Action) bool {
+	for _, v := range permissions[p] {
+		if v == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Workspace is an organization that shares X accounts among members.
+// 代理店などで、複数のスタッフが顧客の投稿を管理するために使用されます
+type Workspace struct {
+	UUID string `firestore:"uuid,omitempty" json:"uuid,omitempty"`
+	Name string `firestore:"name,omitempty" json:"name,omitempty"`
+
+	// AccountIDs is Twitter/X AccountIDs managed by this workspace.
+	// Post.ID, Schedule.OwnerId, Rule.OwnerId, Group.OwnerIdと照合する
+	AccountIDs []string `firestore:"account_ids,omitempty" json:"account_ids,omitempty"`
+
+	Members []Member `firestore:"members,omitempty" json:"members,omitempty"`
+
+	CreatedAt time.Time `firestore:"created_at,omitempty" json:"created_at,omitempty"`
+}
+
+// Member is a User who belongs to Workspace.
+type Member struct {
+	// UserID is User.UUID
+	UserID string `firestore:"user_id,omitempty" json:"user_id,omitempty"`
+	Role   Role   `firestore:"role,omitempty" json:"role,omitempty"`
+
+	AddedAt time.Time `firestore:"added_at,omitempty" json:"added_at,omitempty"`
+}
+
+// NewWorkspace is constructor
+// 作成したUserをOwnerとして登録する
+func NewWorkspace(name, ownerUserID string) *Workspace {
+	now := time.Now()
+	return &Workspace{
+		UUID: uuid.New().String(),
+		Name: name,
+		Members: []Member{
+			{UserID: ownerUserID, Role: RoleOwner, AddedAt: now},
+		},
+		CreatedAt: now,
+	}
+}
+
+// GetID for interface
+func (p Workspace) GetID() string {
+	return p.UUID
+}
+
+// Role メンバーのRoleを返します、メンバーでない場合はRoleNone
+func (p Workspace) Role(userID string) Role {
+	for _, v := range p.Members {
+		if v.UserID == userID {
+			return v.Role
+		}
+	}
+	return RoleNone
+}
+
+// HasAccount Xアカウントがこのワークスペースの管理対象かを確認
+func (p Workspace) HasAccount(ownerID string) bool {
+	for _, v := range p.AccountIDs {
+		if v == ownerID {
+			return true
+		}
+	}
+	return false
+}
+
+// Can ユーザーがActionを実行できるかを確認
+func (p Workspace) Can(userID string, action Action) bool {
+	return p.Role(userID).Can(action)
+}
+
+// Authorize ユーザーがリソースに対してActionを実行できるかを確認します
+// リソースはOwner(Post, Schedule, Rule, Group)の所有者IDで照合する
+func (p Workspace) Authorize(userID string, action Action, resource Owner) error {
+	role := p.Role(userID)
+	if role == RoleNone {
+		return fmt.Errorf("%w: %s", ErrNotMember, userID)
+	}
+
+	if resource != nil && !p.HasAccount(resource.GetOwnerID()) {
+		return fmt.Errorf("%w: %s", ErrOwnerNotInScope, resource.GetOwnerID())
+	}
+
+	if !role.Can(action) {
+		return fmt.Errorf("%w: role %s cannot %s", ErrForbidden, role, action)
+	}
+
+	return nil
+}
+
+// SetMember メンバーを追加、またはRoleを変更します
+func (p *Workspace) SetMember(userID string, role Role) error {
+	if role == RoleNone {
+		return p.RemoveMember(userID)
+	}
+
+	for i, v := range p.Members {
+		if v.UserID == userID {
+			if v.Role == RoleOwner && role != RoleOwner && p.countOwners() <= 1 {
+				return ErrLastOwner
+			}
+			p.Members[i].Role = role
+			return nil
+		}
+	}
+
+	p.Members = append(p.Members, Member{UserID: userID, Role: role, AddedAt: time.Now()})
+	return nil
+}
+
+// RemoveMember メンバーを削除します
+// 最後のOwnerは削除できない
+func (p *Workspace) RemoveMember(userID string) error {
+	for i, v := range p.Members {
+		if v.UserID == userID {
+			if v.Role == RoleOwner && p.countOwners() <= 1 {
+				return ErrLastOwner
+			}
+			p.Members = append(p.Members[:i], p.Members[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrNotMember, userID)
+}
+
+func (p Workspace) countOwners() int {
+	n := 0
+	for _, v := range p.Members {
+		if v.Role == RoleOwner {
+			n++
+		}
+	}
+	return n
+}
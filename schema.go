@@ -0,0 +1,261 @@
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Locale is a language of spreadsheet header.
+type Locale string
+
+const (
+	LocaleEN Locale = "en"
+	LocaleJA Locale = "ja"
+)
+
+// Column is a column definition of spreadsheet, derived from csv tag.
+type Column struct {
+	// Name is csv tag name
+	Name     string
+	Required bool
+	// Flag is a number column used as a boolean, accepts TRUE, ○ etc.
+	Flag bool
+
+	// Labels is display name per locale, used for export.
+	Labels map[Locale]string
+	// Aliases is accepted header names for import, in addition to Name and Labels.
+	Aliases []string
+
+	index int
+	kind  reflect.Kind
+}
+
+// Label 表示名を返します、未設定の場合はName
+func (p Column) Label(locale Locale) string {
+	if v, ok := p.Labels[locale]; ok && v != "" {
+		return v
+	}
+	return p.Name
+}
+
+// Schema is column definitions in csv tag order.
+type Schema struct {
+	Columns []Column
+}
+
+// requiredPostColumns 取り込みに必須のカラム
+var requiredPostColumns = map[string]bool{
+	"text": true,
+}
+
+// flagPostColumns 真偽値の表記も受け付ける数値カラム
+var flagPostColumns = map[string]bool{
+	"checked":    true,
+	"with_files": true,
+}
+
+// PostHeaderLabels Postのカラムの表示名
+// 顧客に配布するテンプレートのヘッダーとして使用する
+var PostHeaderLabels = map[Locale]map[string]string{
+	LocaleJA: {
+		"uuid":        "投稿ID",
+		"id":          "アカウントID",
+		"text":        "本文",
+		"file1":       "画像1",
+		"file2":       "画像2",
+		"file3":       "画像3",
+		"file4":       "画像4",
+		"with_files":  "画像付き",
+		"checked":     "チェック",
+		"priority":    "優先度",
+		"count":       "投稿回数",
+		"post_url":    "投稿URL",
+		"is_schedule": "予約",
+	},
+}
+
+// PostHeaderAliases Postのカラムの別名
+// 表示名以外に、顧客が独自に付けたヘッダー名を受け付ける
+var PostHeaderAliases = map[string][]string{
+	"uuid":        {"UUID"},
+	"id":          {"XID", "ユーザーID", "account_id"},
+	"text":        {"テキスト", "投稿内容", "内容", "body"},
+	"file1":       {"ファイル1", "image1", "file_1"},
+	"file2":       {"ファイル2", "image2", "file_2"},
+	"file3":       {"ファイル3", "image3", "file_3"},
+	"file4":       {"ファイル4", "image4", "file_4"},
+	"with_files":  {"画像添付", "ファイル添付"},
+	"checked":     {"確認済み", "有効"},
+	"priority":    {"優先順位"},
+	"count":       {"回数"},
+	"post_url":    {"URL"},
+	"is_schedule": {"スケジュール", "予約投稿"},
+}
+
+// PostSchema Postのcsvタグからスキーマを生成します
+// PostHeaderLabels, PostHeaderAliasesを適用する
+func PostSchema() Schema {
+	t := reflect.TypeOf(Post{})
+	schema := Schema{}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("csv"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		labels := make(map[Locale]string)
+		for locale, v := range PostHeaderLabels {
+			if label, ok := v[name]; ok {
+				labels[locale] = label
+			}
+		}
+
+		schema.Columns = append(schema.Columns, Column{
+			Name:     name,
+			Required: requiredPostColumns[name],
+			Flag:     flagPostColumns[name],
+			Labels:   labels,
+			Aliases:  append([]string{}, PostHeaderAliases[name]...),
+			index:    i,
+			kind:     t.Field(i).Type.Kind(),
+		})
+	}
+	return schema
+}
+
+// WithAliases カラムの別名を追加したSchemaを返します
+// aliasesはカラム名(csvタグ名) -> 別名
+func (p Schema) WithAliases(aliases map[string][]string) (Schema, error) {
+	columns := make([]Column, len(p.Columns))
+	copy(columns, p.Columns)
+
+	for name, v := range aliases {
+		found := false
+		for i := range columns {
+			if columns[i].Name == name {
+				columns[i].Aliases = append(append([]string{}, columns[i].Aliases...), v...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return p, fmt.Errorf("unknown column: %s", name)
+		}
+	}
+
+	return Schema{Columns: columns}, nil
+}
+
+// Names カラム名を返します
+func (p Schema) Names() []string {
+	names := make([]string, len(p.Columns))
+	for i, v := range p.Columns {
+		names[i] = v.Name
+	}
+	return names
+}
+
+// Header localeの表示名を返します
+func (p Schema) Header(locale Locale) []string {
+	names := make([]string, len(p.Columns))
+	for i, v := range p.Columns {
+		names[i] = v.Label(locale)
+	}
+	return names
+}
+
+// Lookup ヘッダー名からカラムを返します
+// カラム名、表示名、別名のいずれかと、大文字小文字・全角半角を区別せずに照合する
+func (p Schema) Lookup(header string) (Column, bool) {
+	key := NormalizeHeader(header)
+	for _, v := range p.Columns {
+		if NormalizeHeader(v.Name) == key {
+			return v, true
+		}
+		for _, label := range v.Labels {
+			if NormalizeHeader(label) == key {
+				return v, true
+			}
+		}
+		for _, alias := range v.Aliases {
+			if NormalizeHeader(alias) == key {
+				return v, true
+			}
+		}
+	}
+	return Column{}, false
+}
+
+// Positions ヘッダー行からカラム名 -> 位置(0始まり)を返します
+// 未知のカラムは含まない、重複したカラムは先頭を優先する
+func (p Schema) Positions(header []string) map[string]int {
+	positions := make(map[string]int)
+	for i, h := range header {
+		c, ok := p.Lookup(h)
+		if !ok {
+			continue
+		}
+		if _, exist := positions[c.Name]; !exist {
+			positions[c.Name] = i
+		}
+	}
+	return positions
+}
+
+// NormalizeHeader ヘッダー名を照合用に正規化します
+// 全角英数字を半角、半角カナを全角にし、小文字化、空白と区切り文字を除去する
+// 画像１ -> 画像1, ﾃｷｽﾄ -> テキスト, Post_URL -> posturl
+func NormalizeHeader(s string) string {
+	s = strings.ToLower(ToFullWidthKana(ToHalfWidth(s)))
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\n', '_', '-', '・':
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// halfWidthKana 半角カナ -> 全角カナ
+var halfWidthKana = map[rune]rune{
+	'ｦ': 'ヲ', 'ｧ': 'ァ', 'ｨ': 'ィ', 'ｩ': 'ゥ', 'ｪ': 'ェ', 'ｫ': 'ォ', 'ｬ': 'ャ', 'ｭ': 'ュ', 'ｮ': 'ョ', 'ｯ': 'ッ',
+	'ｰ': 'ー', 'ｱ': 'ア', 'ｲ': 'イ', 'ｳ': 'ウ', 'ｴ': 'エ', 'ｵ': 'オ', 'ｶ': 'カ', 'ｷ': 'キ', 'ｸ': 'ク', 'ｹ': 'ケ',
+	'ｺ': 'コ', 'ｻ': 'サ', 'ｼ': 'シ', 'ｽ': 'ス', 'ｾ': 'セ', 'ｿ': 'ソ', 'ﾀ': 'タ', 'ﾁ': 'チ', 'ﾂ': 'ツ', 'ﾃ': 'テ',
+	'ﾄ': 'ト', 'ﾅ': 'ナ', 'ﾆ': 'ニ', 'ﾇ': 'ヌ', 'ﾈ': 'ネ', 'ﾉ': 'ノ', 'ﾊ': 'ハ', 'ﾋ': 'ヒ', 'ﾌ': 'フ', 'ﾍ': 'ヘ',
+	'ﾎ': 'ホ', 'ﾏ': 'マ', 'ﾐ': 'ミ', 'ﾑ': 'ム', 'ﾒ': 'メ', 'ﾓ': 'モ', 'ﾔ': 'ヤ', 'ﾕ': 'ユ', 'ﾖ': 'ヨ', 'ﾗ': 'ラ',
+	'ﾘ': 'リ', 'ﾙ': 'ル', 'ﾚ': 'レ', 'ﾛ': 'ロ', 'ﾜ': 'ワ', 'ﾝ': 'ン', '･': '・', '｢': '「', '｣': '」', '､': '、', '｡': '。',
+}
+
+// ToFullWidthKana 半角カナを全角カナに変換します、濁点・半濁点は結合する
+func ToFullWidthKana(s string) string {
+	var b strings.Builder
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r, ok := halfWidthKana[runes[i]]
+		if !ok {
+			b.WriteRune(runes[i])
+			continue
+		}
+
+		if i+1 < len(runes) {
+			switch runes[i+1] {
+			case 'ﾞ': // 濁点
+				if r == 'ウ' {
+					r = 'ヴ'
+					i++
+				} else if strings.ContainsRune("カキクケコサシスセソタチツテトハヒフヘホ", r) {
+					r++
+					i++
+				}
+			case 'ﾟ': // 半濁点
+				if strings.ContainsRune("ハヒフヘホ", r) {
+					r += 2
+					i++
+				}
+			}
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// SheetClient is a minimal Google Sheets client used by SheetSync.
+// 行番号、列番号は1始まり(A1 = row 1, col 1)
+type SheetClient interface {
+	// ReadRows シートの全ての値を返します、1行目はヘッダー
+	ReadRows(ctx context.Context, spreadID, sheet string) ([][]string, error)
+	// UpdateCells 指定したセルを更新します
+	UpdateCells(ctx context.Context, spreadID, sheet string, cells []CellUpdate) error
+	// WriteRows startRowから行を書き込みます、A列から順に値を設定する
+	WriteRows(ctx context.Context, spreadID, sheet string, startRow int, rows [][]string) error
+}
+
+// CellUpdate is a value of a cell to update.
+type CellUpdate struct {
+	Row   int
+	Col   int
+	Value string
+}
+
+// FakeSheetClient is an in-memory SheetClient for tests.
+type FakeSheetClient struct {
+	mu     sync.Mutex
+	sheets map[string][][]string
+}
+
+// NewFakeSheetClient is constructor
+func NewFakeSheetClient() *FakeSheetClient {
+	return &FakeSheetClient{
+		sheets: make(map[string][][]string),
+	}
+}
+
+func fakeSheetKey(spreadID, sheet string) string {
+	return spreadID + "/" + sheet
+}
+
+// SetRows シートの値を置き換えます
+func (p *FakeSheetClient) SetRows(spreadID, sheet string, rows [][]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sheets[fakeSheetKey(spreadID, sheet)] = copyRows(rows)
+}
+
+// ReadRows シートの全ての値を返します
+func (p *FakeSheetClient) ReadRows(ctx context.Context, spreadID, sheet string) ([][]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	rows, ok := p.sheets[fakeSheetKey(spreadID, sheet)]
+	if !ok {
+		return nil, fmt.Errorf("%w: sheet %s/%s", ErrNotFound, spreadID, sheet)
+	}
+	return copyRows(rows), nil
+}
+
+// UpdateCells 指定したセルを更新します、範囲外の場合はシートを拡張する
+func (p *FakeSheetClient) UpdateCells(ctx context.Context, spreadID, sheet string, cells []CellUpdate) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := fakeSheetKey(spreadID, sheet)
+	rows := p.sheets[key]
+	for _, c := range cells {
+		if c.Row < 1 || c.Col < 1 {
+			return fmt.Errorf("invalid cell: row %d, col %d", c.Row, c.Col)
+		}
+		rows = setCell(rows, c.Row, c.Col, c.Value)
+	}
+	p.sheets[key] = rows
+
+	return nil
+}
+
+// WriteRows startRowから行を書き込みます
+func (p *FakeSheetClient) WriteRows(ctx context.Context, spreadID, sheet string, startRow int, values [][]string) error {
+	if startRow < 1 {
+		return fmt.Errorf("invalid row: %d", startRow)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := fakeSheetKey(spreadID, sheet)
+	rows := p.sheets[key]
+	for i, row := range values {
+		for j, v := range row {
+			rows = setCell(rows, startRow+i, j+1, v)
+		}
+	}
+	p.sheets[key] = rows
+
+	return nil
+}
+
+func setCell(rows [][]string, row, col int, value string) [][]string {
+	for len(rows) < row {
+		rows = append(rows, []string{})
+	}
+	for len(rows[row-1]) < col {
+		rows[row-1] = append(rows[row-1], "")
+	}
+	rows[row-1][col-1] = value
+	return rows
+}
+
+func copyRows(rows [][]string) [][]string {
+	dst := make([][]string, len(rows))
+	for i, row := range rows {
+		dst[i] = append([]string{}, row...)
+	}
+	return dst
+}
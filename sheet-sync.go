@@ -0,0 +1,466 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SheetSync is a bidirectional sync between Google Sheets rows and Posts.
+// シートの本文などの内容をPostへ、投稿結果(post_url, count, checked)をシートへ反映する
+// 行の識別にはuuidカラムを使用し、無い場合はヘッダーに追加する
+type SheetSync struct {
+	Client SheetClient
+	Store  Store
+
+	// Collection is posts collection name.
+	Collection string
+	// StateCollection is collection name for SyncState.
+	StateCollection string
+	// Sheet is sheet name, e.g. "posts"
+	Sheet string
+	// Schema is header definitions, PostSchema if empty.
+	Schema Schema
+}
+
+// SyncState is a snapshot of last sync per spread sheet.
+// 前回同期時の値と比較し、シートとPostのどちら側で変更されたかを判定する
+type SyncState struct {
+	SpreadID string                  `firestore:"spread_id,omitempty" json:"spread_id,omitempty"`
+	Sheet    string                  `firestore:"sheet,omitempty" json:"sheet,omitempty"`
+	Rows     map[string]SyncSnapshot `firestore:"rows,omitempty" json:"rows,omitempty"`
+	SyncedAt time.Time               `firestore:"synced_at,omitempty" json:"synced_at,omitempty"`
+}
+
+// SyncSnapshot is hashes of a Post at last sync.
+type SyncSnapshot struct {
+	Row     int    `firestore:"row,omitempty" json:"row,omitempty"`
+	Content string `firestore:"content,omitempty" json:"content,omitempty"`
+	Result  string `firestore:"result,omitempty" json:"result,omitempty"`
+}
+
+// SyncConflict is a row edited on both sides since last sync.
+type SyncConflict struct {
+	Row  int    `json:"row"`
+	UUID string `json:"uuid"`
+	// Part is "content" or "result"
+	Part   string `json:"part"`
+	Sheet  Post   `json:"sheet"`
+	Stored Post   `json:"stored"`
+}
+
+// SyncResult is a result of SheetSync.Sync.
+type SyncResult struct {
+	// Created is Posts created from new rows.
+	Created []Post
+	// Updated is Posts updated by sheet edits.
+	Updated []Post
+	// WrittenBack is UUIDs whose result columns were written to sheet.
+	WrittenBack []string
+	// Appended is Posts appended to sheet as new rows.
+	Appended []Post
+	// Removed is UUIDs synced before but no longer in sheet. Postは削除しない
+	Removed []string
+
+	// Conflicts is rows not applied, resolved when both sides have the same value.
+	Conflicts []SyncConflict
+	Report    *ImportReport
+}
+
+// contentColumns シートで編集される内容のカラム
+var contentColumns = []string{"text", "file1", "file2", "file3", "file4", "with_files", "priority", "is_schedule"}
+
+// resultColumns 投稿結果としてシートへ書き戻すカラム
+var resultColumns = []string{"post_url", "count", "checked"}
+
+// SyncStateKey SyncStateのドキュメントキー
+func SyncStateKey(spreadID, sheet string) string {
+	return spreadID + "_" + sheet
+}
+
+func (p *SheetSync) stateKey(spreadID string) string {
+	return SyncStateKey(spreadID, p.Sheet)
+}
+
+// LoadState 前回の同期状態を取得します、未同期の場合は空の状態を返す
+func (p *SheetSync) LoadState(ctx context.Context, spreadID string) (SyncState, error) {
+	return loadSyncState(ctx, p.Store, p.StateCollection, spreadID, p.Sheet)
+}
+
+func loadSyncState(ctx context.Context, store Store, colName, spreadID, sheet string) (SyncState, error) {
+	var state SyncState
+	if err := store.Get(ctx, colName, SyncStateKey(spreadID, sheet), &state); err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			return SyncState{}, err
+		}
+		state = SyncState{SpreadID: spreadID, Sheet: sheet}
+	}
+	if state.Rows == nil {
+		state.Rows = make(map[string]SyncSnapshot)
+	}
+	return state, nil
+}
+
+// Sync シートとPostを同期します
+// accountIDはPost.ID、シートのidカラムの値によらず全ての行をaccountIDのPostとする
+func (p *SheetSync) Sync(ctx context.Context, accountID, spreadID string) (*SyncResult, error) {
+	schema := p.Schema
+	if len(schema.Columns) == 0 {
+		schema = PostSchema()
+	}
+
+	rows, err := p.Client.ReadRows(ctx, spreadID, p.Sheet)
+	if err != nil {
+		return nil, fmt.Errorf("error reading sheet: %v", err)
+	}
+
+	var updates []CellUpdate
+	if len(rows) == 0 || isBlankRow(rows[0]) {
+		// 空のシートにはヘッダーを書き込む
+		header := schema.Names()
+		rows = [][]string{header}
+		for i, v := range header {
+			updates = append(updates, CellUpdate{Row: 1, Col: i + 1, Value: v})
+		}
+	}
+
+	header := rows[0]
+	positions := schema.Positions(header)
+	if _, ok := positions["uuid"]; !ok {
+		header = append(append([]string{}, header...), "uuid")
+		positions["uuid"] = len(header) - 1
+		updates = append(updates, CellUpdate{Row: 1, Col: len(header), Value: "uuid"})
+	}
+
+	rowPosts, report := ImportPostRows(schema, header, rows[1:])
+	result := &SyncResult{Report: report}
+	if rowPosts == nil {
+		return result, report.Err()
+	}
+
+	state, err := p.LoadState(ctx, spreadID)
+	if err != nil {
+		return nil, err
+	}
+	next := SyncState{SpreadID: spreadID, Sheet: p.Sheet, Rows: make(map[string]SyncSnapshot)}
+
+	// ゴミ箱の投稿も含めて取得し、行の再作成を防ぐ
+	posts, err := listPosts(ctx, p.Store, p.Collection, accountID)
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[string]Post, len(posts))
+	for _, v := range posts {
+		stored[v.UUID] = v
+	}
+
+	uuidCol := positions["uuid"] + 1
+	seen := make(map[string]bool)
+	// 新しい行のPostは、UUIDをシートに書き込んだ後に保存する
+	var creates []Post
+
+	// エラーのある行は取り込まないが、シートには存在するため削除・追記の対象としない
+	imported := make(map[int]bool, len(rowPosts))
+	for _, rp := range rowPosts {
+		imported[rp.Row] = true
+	}
+	for i, row := range rows[1:] {
+		if imported[i+2] || isBlankRow(row) || positions["uuid"] >= len(row) {
+			continue
+		}
+		id := strings.TrimSpace(row[positions["uuid"]])
+		if _, exist := stored[id]; !exist || seen[id] {
+			continue
+		}
+		seen[id] = true
+		if v, ok := state.Rows[id]; ok {
+			next.Rows[id] = v
+		}
+	}
+
+	for _, rp := range rowPosts {
+		post := rp.Post
+		// idカラムでアカウントを変更させない
+		post.ID = accountID
+
+		cur, exist := stored[post.UUID]
+		if post.UUID == "" || seen[post.UUID] || !exist {
+			// 新しい行、コピーされた行、または他のアカウントや存在しないUUIDの行
+			// 他のアカウントのPostを上書きしないよう、UUIDを振り直す
+			post.UUID = uuid.New().String()
+			updates = append(updates, CellUpdate{Row: rp.Row, Col: uuidCol, Value: post.UUID})
+			seen[post.UUID] = true
+			post.SetCreateAt()
+
+			creates = append(creates, post)
+			result.Created = append(result.Created, post)
+			next.Rows[post.UUID] = snapshotOf(rp.Row, post, positions)
+			continue
+		}
+		seen[post.UUID] = true
+
+		if cur.IsDeleted() {
+			next.Rows[post.UUID] = state.Rows[post.UUID]
+			continue
+		}
+
+		base, hasBase := state.Rows[post.UUID]
+		if !hasBase {
+			// 初回同期: 内容はシート、投稿結果はPostを優先する
+			base = SyncSnapshot{
+				Content: contentHash(cur, positions),
+				Result:  resultHash(post, positions),
+			}
+		}
+
+		updated, contentConflict, resultConflict := false, false, false
+
+		sheetContent, storeContent := contentHash(post, positions), contentHash(cur, positions)
+		sc, tc := sheetContent != base.Content, storeContent != base.Content
+		switch {
+		case sc && tc && sheetContent != storeContent:
+			result.Conflicts = append(result.Conflicts, SyncConflict{Row: rp.Row, UUID: post.UUID, Part: "content", Sheet: post, Stored: cur})
+			contentConflict = true
+		case sc:
+			applyContent(&cur, post, positions)
+			updated = true
+		}
+
+		sheetResult, storeResult := resultHash(post, positions), resultHash(cur, positions)
+		sr, tr := sheetResult != base.Result, storeResult != base.Result
+		switch {
+		case sr && tr && sheetResult != storeResult:
+			result.Conflicts = append(result.Conflicts, SyncConflict{Row: rp.Row, UUID: post.UUID, Part: "result", Sheet: post, Stored: cur})
+			resultConflict = true
+		case tr:
+			updates = append(updates, resultCells(rp.Row, cur, positions)...)
+			result.WrittenBack = append(result.WrittenBack, cur.UUID)
+		case sr:
+			applyResult(&cur, post, positions)
+			updated = true
+		}
+
+		if updated {
+			if err := p.Store.Set(ctx, p.Collection, cur.UUID, cur); err != nil {
+				return nil, err
+			}
+			result.Updated = append(result.Updated, cur)
+		}
+
+		// 競合した値は、解消されるまで前回の状態を保持する
+		snapshot := snapshotOf(rp.Row, cur, positions)
+		if contentConflict {
+			snapshot.Content = base.Content
+		}
+		if resultConflict {
+			snapshot.Result = base.Result
+		}
+		next.Rows[cur.UUID] = snapshot
+	}
+
+	// シートに存在しないPost
+	var appends [][]string
+	appendRow := lastRow(rows) + 1
+	for _, v := range posts {
+		if seen[v.UUID] || v.IsDeleted() {
+			continue
+		}
+		if _, synced := state.Rows[v.UUID]; synced {
+			// 前回同期後にシートから削除された
+			result.Removed = append(result.Removed, v.UUID)
+			continue
+		}
+
+		row := appendRow + len(appends)
+		appends = append(appends, encodeSheetRow(schema, header, v))
+		result.Appended = append(result.Appended, v)
+		next.Rows[v.UUID] = snapshotOf(row, v, positions)
+	}
+
+	// UUIDの書き込みに失敗した場合は保存しない、次の同期で同じ行のPostを重複して作成しないため
+	if len(updates) > 0 {
+		if err := p.Client.UpdateCells(ctx, spreadID, p.Sheet, updates); err != nil {
+			return nil, fmt.Errorf("error updating sheet: %v", err)
+		}
+	}
+	for _, v := range creates {
+		if err := p.Store.Set(ctx, p.Collection, v.UUID, v); err != nil {
+			return nil, err
+		}
+	}
+	if len(appends) > 0 {
+		if err := p.Client.WriteRows(ctx, spreadID, p.Sheet, appendRow, appends); err != nil {
+			return nil, fmt.Errorf("error appending sheet: %v", err)
+		}
+	}
+
+	next.SyncedAt = time.Now()
+	if err := p.Store.Set(ctx, p.StateCollection, p.stateKey(spreadID), next); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func snapshotOf(row int, post Post, positions map[string]int) SyncSnapshot {
+	return SyncSnapshot{
+		Row:     row,
+		Content: contentHash(post, positions),
+		Result:  resultHash(post, positions),
+	}
+}
+
+// hasContentColumn 内容のカラムがシートに存在するか、positionsがnilの場合は全てのカラムとする
+func hasContentColumn(positions map[string]int, name string) bool {
+	if positions == nil {
+		return true
+	}
+	_, ok := positions[name]
+	return ok
+}
+
+// contentHash シートで編集される内容のハッシュ、シートに存在するカラムのみを対象とする
+// カラムの無い値はシート側がゼロ値となるため、含めると変更として扱われ保存済みの値を上書きする
+func contentHash(p Post, positions map[string]int) string {
+	values := []string{}
+	for _, name := range contentColumns {
+		if !hasContentColumn(positions, name) {
+			continue
+		}
+		values = append(values, name+"="+contentValue(p, name))
+	}
+	return hashFields(values...)
+}
+
+func contentValue(p Post, name string) string {
+	switch name {
+	case "text":
+		return p.Text
+	case "file1":
+		return p.File1
+	case "file2":
+		return p.File2
+	case "file3":
+		return p.File3
+	case "file4":
+		return p.File4
+	case "with_files":
+		return strconv.Itoa(p.WithFiles)
+	case "priority":
+		return strconv.Itoa(p.Priority)
+	case "is_schedule":
+		return strconv.FormatBool(p.IsSchedule)
+	}
+	return ""
+}
+
+// resultHash 投稿結果のハッシュ、シートに存在するカラムのみを対象とする
+func resultHash(p Post, positions map[string]int) string {
+	values := []string{}
+	for _, name := range resultColumns {
+		if _, ok := positions[name]; !ok {
+			continue
+		}
+		values = append(values, name+"="+resultValue(p, name))
+	}
+	return hashFields(values...)
+}
+
+func resultValue(p Post, name string) string {
+	switch name {
+	case "post_url":
+		return p.PostURL
+	case "count":
+		return strconv.Itoa(p.Count)
+	case "checked":
+		return strconv.Itoa(p.Checked)
+	}
+	return ""
+}
+
+func hashFields(values ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(values, "\x1f")))
+	return hex.EncodeToString(sum[:])
+}
+
+// applyContent シートに存在する内容のカラムのみを反映します
+func applyContent(dst *Post, src Post, positions map[string]int) {
+	for _, name := range contentColumns {
+		if !hasContentColumn(positions, name) {
+			continue
+		}
+		switch name {
+		case "text":
+			dst.Text = src.Text
+		case "file1":
+			dst.File1 = src.File1
+		case "file2":
+			dst.File2 = src.File2
+		case "file3":
+			dst.File3 = src.File3
+		case "file4":
+			dst.File4 = src.File4
+		case "with_files":
+			dst.WithFiles = src.WithFiles
+		case "priority":
+			dst.Priority = src.Priority
+		case "is_schedule":
+			dst.IsSchedule = src.IsSchedule
+		}
+	}
+}
+
+func applyResult(dst *Post, src Post, positions map[string]int) {
+	if _, ok := positions["post_url"]; ok {
+		dst.PostURL = src.PostURL
+	}
+	if _, ok := positions["count"]; ok {
+		dst.Count = src.Count
+	}
+	if _, ok := positions["checked"]; ok {
+		dst.Checked = src.Checked
+	}
+}
+
+func resultCells(row int, post Post, positions map[string]int) []CellUpdate {
+	cells := []CellUpdate{}
+	for _, name := range resultColumns {
+		i, ok := positions[name]
+		if !ok {
+			continue
+		}
+		cells = append(cells, CellUpdate{Row: row, Col: i + 1, Value: resultValue(post, name)})
+	}
+	return cells
+}
+
+// encodeSheetRow ヘッダーの並びに合わせてPostを行に変換します
+func encodeSheetRow(schema Schema, header []string, post Post) []string {
+	values := encodePostRow(schema, post)
+	byName := make(map[string]string, len(values))
+	for i, c := range schema.Columns {
+		byName[c.Name] = values[i]
+	}
+
+	row := make([]string, len(header))
+	for name, i := range schema.Positions(header) {
+		row[i] = byName[name]
+	}
+	return row
+}
+
+// lastRow 値のある最終行(1始まり)を返します
+func lastRow(rows [][]string) int {
+	n := len(rows)
+	for n > 0 && isBlankRow(rows[n-1]) {
+		n--
+	}
+	return n
+}
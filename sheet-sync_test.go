@@ -0,0 +1,93 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingSheetClient UpdateCellsのみ失敗する
+type failingSheetClient struct {
+	*FakeSheetClient
+}
+
+func (p failingSheetClient) UpdateCells(ctx context.Context, spreadID, sheet string, cells []CellUpdate) error {
+	return errors.New("update failed")
+}
+
+func newTestSheetSync(client SheetClient, store Store) *SheetSync {
+	return &SheetSync{
+		Client:          client,
+		Store:           store,
+		Collection:      "posts",
+		StateCollection: "sync_states",
+		Sheet:           "posts",
+	}
+}
+
+func TestSheetSyncPartialColumns(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	stored := Post{UUID: "u1", ID: "acct", Text: "before", Priority: 5, File1: "a.png", WithFiles: 1, IsSchedule: true}
+	require.NoError(t, store.Set(ctx, "posts", stored.UUID, stored))
+
+	client := NewFakeSheetClient()
+	client.SetRows("spread", "posts", [][]string{
+		{"uuid", "text"},
+		{"u1", "before"},
+	})
+	sync := newTestSheetSync(client, store)
+
+	// 初回同期でも、シートに無いカラムをゼロ値で上書きしない
+	result, err := sync.Sync(ctx, "acct", "spread")
+	require.NoError(t, err)
+	assert.Empty(t, result.Updated)
+
+	client.SetRows("spread", "posts", [][]string{
+		{"uuid", "text"},
+		{"u1", "after"},
+	})
+	result, err = sync.Sync(ctx, "acct", "spread")
+	require.NoError(t, err)
+	require.Len(t, result.Updated, 1)
+
+	var got Post
+	require.NoError(t, store.Get(ctx, "posts", "u1", &got))
+	assert.Equal(t, "after", got.Text)
+	assert.Equal(t, 5, got.Priority)
+	assert.Equal(t, "a.png", got.File1)
+	assert.Equal(t, 1, got.WithFiles)
+	assert.True(t, got.IsSchedule)
+}
+
+func TestSheetSyncWritesUUIDBeforeCreate(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	client := NewFakeSheetClient()
+	client.SetRows("spread", "posts", [][]string{
+		{"uuid", "text"},
+		{"", "new"},
+	})
+
+	_, err := newTestSheetSync(failingSheetClient{client}, store).Sync(ctx, "acct", "spread")
+	require.Error(t, err)
+
+	posts, err := ListPosts(ctx, store, "posts", "acct")
+	require.NoError(t, err)
+	assert.Empty(t, posts, "posts are not created until uuids are written")
+
+	result, err := newTestSheetSync(client, store).Sync(ctx, "acct", "spread")
+	require.NoError(t, err)
+	require.Len(t, result.Created, 1)
+
+	rows, err := client.ReadRows(ctx, "spread", "posts")
+	require.NoError(t, err)
+	assert.Equal(t, result.Created[0].UUID, rows[1][0])
+
+	posts, err = ListPosts(ctx, store, "posts", "acct")
+	require.NoError(t, err)
+	assert.Len(t, posts, 1)
+}
@@ -0,0 +1,184 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// MemoryStore is an in-memory Store for tests and local development.
+// 値は代入によって保持するため、slice, mapのフィールドは呼び出し側と共有される点に注意
+type MemoryStore struct {
+	mu   sync.RWMutex
+	docs map[string]map[string]any
+}
+
+// NewMemoryStore is constructor
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		docs: make(map[string]map[string]any),
+	}
+}
+
+// Get dataはpointer, 参照渡し
+func (p *MemoryStore) Get(ctx context.Context, colName, docKey string, data any) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	v, ok := p.docs[colName][docKey]
+	if !ok {
+		return fmt.Errorf("%w: %s/%s", ErrNotFound, colName, docKey)
+	}
+
+	return assign(v, data)
+}
+
+// assign 保持している値をdataのpointerに設定します
+func assign(v, data any) error {
+	dst := reflect.ValueOf(data)
+	if dst.Kind() != reflect.Pointer || dst.IsNil() {
+		return fmt.Errorf("error getting data: data must be non-nil pointer, data type: %s", dst.Type())
+	}
+	src := reflect.ValueOf(v)
+	if !src.Type().AssignableTo(dst.Elem().Type()) {
+		return fmt.Errorf("error getting data: stored %s, data type: %s", src.Type(), dst.Type())
+	}
+	dst.Elem().Set(src)
+
+	return nil
+}
+
+// Set dataはnot pointer, 値渡し
+func (p *MemoryStore) Set(ctx context.Context, colName, docKey string, data any) error {
+	v := reflect.ValueOf(data)
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return fmt.Errorf("error setting document: nil data")
+		}
+		data = v.Elem().Interface()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.docs[colName] == nil {
+		p.docs[colName] = make(map[string]any)
+	}
+	p.docs[colName][docKey] = data
+
+	return nil
+}
+
+// Update for Updater, ロックしたまま読み込みと書き込みを行う
+func (p *MemoryStore) Update(ctx context.Context, colName, docKey string, data any, fn func(exists bool) error) error {
+	if v := reflect.ValueOf(data); v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("error updating document: data must be non-nil pointer, data type: %T", data)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	v, exists := p.docs[colName][docKey]
+	if exists {
+		if err := assign(v, data); err != nil {
+			return err
+		}
+	}
+	if err := fn(exists); err != nil {
+		return err
+	}
+
+	if p.docs[colName] == nil {
+		p.docs[colName] = make(map[string]any)
+	}
+	p.docs[colName][docKey] = reflect.ValueOf(data).Elem().Interface()
+	return nil
+}
+
+// Delete ドキュメントを物理削除します
+func (p *MemoryStore) Delete(ctx context.Context, colName, docKey string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.docs[colName], docKey)
+	return nil
+}
+
+// List dataはsliceのpointer, キー順で返却
+func (p *MemoryStore) List(ctx context.Context, colName string, filters []Filter, data any) error {
+	dst := reflect.ValueOf(data)
+	if dst.Kind() != reflect.Pointer || dst.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("error listing documents: data must be pointer to slice, data type: %s", dst.Type())
+	}
+	slice := dst.Elem()
+	elemType := slice.Type().Elem()
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	keys := make([]string, 0, len(p.docs[colName]))
+	for k := range p.docs[colName] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := p.docs[colName][k]
+		src := reflect.ValueOf(v)
+		if !src.Type().AssignableTo(elemType) {
+			continue
+		}
+		if !matchFilters(v, filters) {
+			continue
+		}
+		slice = reflect.Append(slice, src)
+	}
+	dst.Elem().Set(slice)
+
+	return nil
+}
+
+// Iterate キー順に1件ずつ処理します
+// 処理中の書き込みを妨げないよう、キーの一覧のみを先に取得する
+func (p *MemoryStore) Iterate(ctx context.Context, colName string, filters []Filter, fn func(key string, decode func(data any) error) error) error {
+	p.mu.RLock()
+	keys := make([]string, 0, len(p.docs[colName]))
+	for k := range p.docs[colName] {
+		keys = append(keys, k)
+	}
+	p.mu.RUnlock()
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		p.mu.RLock()
+		v, ok := p.docs[colName][k]
+		p.mu.RUnlock()
+		if !ok || !matchFilters(v, filters) {
+			continue
+		}
+
+		if err := fn(k, func(data any) error { return assign(v, data) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Collections 保持しているコレクション名を返します
+func (p *MemoryStore) Collections() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	names := make([]string, 0, len(p.docs))
+	for k := range p.docs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
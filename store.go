@@ -0,0 +1,149 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var (
+	ErrNotFound      = errors.New("document not found")
+	ErrAlreadyExists = errors.New("document already exists")
+)
+
+// Store is a document store.
+// ClientForFirestore, MemoryStoreが実装し、テストやエミュレータ無しの環境ではMemoryStoreを使用する
+type Store interface {
+	// Get dataはpointer, 参照渡し
+	Get(ctx context.Context, colName, docKey string, data any) error
+	// Set dataは1ドキュメント分の値
+	Set(ctx context.Context, colName, docKey string, data any) error
+	// Delete ドキュメントを物理削除します
+	Delete(ctx context.Context, colName, docKey string) error
+	// List dataはsliceのpointer, filtersはfirestoreタグ名による等価条件
+	List(ctx context.Context, colName string, filters []Filter, data any) error
+}
+
+// Iterator is implemented by Store that can stream documents without loading all.
+// keyはドキュメントキー、decodeはdataのpointerに1ドキュメント分の値を設定する
+type Iterator interface {
+	Iterate(ctx context.Context, colName string, filters []Filter, fn func(key string, decode func(data any) error) error) error
+}
+
+// Updater is implemented by Store that can read and write a document atomically.
+// ClientForFirestoreはトランザクション、MemoryStoreはロックで実装する
+type Updater interface {
+	// Update docKeyのドキュメントをdata(pointer)に読み込み、fnで変更したdataを書き込みます
+	// ドキュメントが無い場合はexistsがfalse、fnがエラーを返した場合は書き込まない
+	Update(ctx context.Context, colName, docKey string, data any, fn func(exists bool) error) error
+}
+
+// UpdateDocument ドキュメントを読み込み、fnで変更して書き込みます
+// StoreがUpdaterを実装していない場合はGet, Setで代替し、同時書き込みは保護されない
+func UpdateDocument(ctx context.Context, store Store, colName, docKey string, data any, fn func(exists bool) error) error {
+	if u, ok := store.(Updater); ok {
+		return u.Update(ctx, colName, docKey, data, fn)
+	}
+
+	exists := true
+	if err := store.Get(ctx, colName, docKey, data); err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		exists = false
+	}
+	if err := fn(exists); err != nil {
+		return err
+	}
+	return store.Set(ctx, colName, docKey, data)
+}
+
+// CreateDocument ドキュメントが無い場合のみ書き込みます、既にある場合はErrAlreadyExists
+func CreateDocument(ctx context.Context, store Store, colName, docKey string, data any) error {
+	v := reflect.ValueOf(data)
+	for v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	cur := reflect.New(v.Type())
+	return UpdateDocument(ctx, store, colName, docKey, cur.Interface(), func(exists bool) error {
+		if exists {
+			return fmt.Errorf("%w: %s/%s", ErrAlreadyExists, colName, docKey)
+		}
+		cur.Elem().Set(v)
+		return nil
+	})
+}
+
+// Each コレクションのドキュメントを1件ずつ処理します
+// StoreがIteratorを実装している場合はストリーミングで取得する
+func Each[T any](ctx context.Context, store Store, colName string, filters []Filter, fn func(T) error) error {
+	if it, ok := store.(Iterator); ok {
+		return it.Iterate(ctx, colName, filters, func(key string, decode func(data any) error) error {
+			var v T
+			if err := decode(&v); err != nil {
+				return err
+			}
+			return fn(v)
+		})
+	}
+
+	var list []T
+	if err := store.List(ctx, colName, filters, &list); err != nil {
+		return err
+	}
+	for _, v := range list {
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Filter is an equality condition by firestore tag name.
+type Filter struct {
+	Field string
+	Value any
+}
+
+// Where Filterのコンストラクタ
+func Where(field string, value any) Filter {
+	return Filter{Field: field, Value: value}
+}
+
+// fieldByTag firestoreタグ名から構造体のフィールド値を返します
+func fieldByTag(v reflect.Value, tag string) (reflect.Value, bool) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}, false
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("firestore"), ",")
+		if name == tag {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// matchFilters 全てのFilterに一致するかを確認
+func matchFilters(v any, filters []Filter) bool {
+	for _, f := range filters {
+		field, ok := fieldByTag(reflect.ValueOf(v), f.Field)
+		if !ok {
+			return false
+		}
+		if !reflect.DeepEqual(field.Interface(), f.Value) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,282 @@
+package models
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// UsageGranularity is a period of UsageRollup.
+type UsageGranularity string
+
+const (
+	UsageHourly UsageGranularity = "hour"
+	UsageDaily  UsageGranularity = "day"
+)
+
+// start 期間の開始時刻を返します、UTC
+func (p UsageGranularity) start(t time.Time) time.Time {
+	t = t.UTC()
+	if p == UsageDaily {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return t.Truncate(time.Hour)
+}
+
+// UsageRollup is usage count of an account and a channel in a period.
+// Subscribeの使用回数は期間ごとにリセットされるため、履歴として別に保存する
+type UsageRollup struct {
+	AccountID   string           `firestore:"account_id" json:"account_id" csv:"account_id"`
+	Channel     string           `firestore:"channel" json:"channel" csv:"channel"`
+	Granularity UsageGranularity `firestore:"granularity" json:"granularity" csv:"granularity"`
+	Start       time.Time        `firestore:"start" json:"start" csv:"start"`
+	Count       int64            `firestore:"count" json:"count" csv:"count"`
+
+	UpdatedAt time.Time `firestore:"updated_at" json:"updated_at" csv:"-"`
+}
+
+// usageKey e.g. {account}_{channel}_day_20240102T00
+func usageKey(accountID, channel string, g UsageGranularity, start time.Time) string {
+	return fmt.Sprintf("%s_%s_%s_%s", accountID, channel, g, start.Format("20060102T15"))
+}
+
+// UsageRecorder persists usage rollups per hour and day.
+type UsageRecorder struct {
+	Store      Store
+	Collection string
+}
+
+// Record 時間別、日別の使用回数にn回分を加算します
+// 読み込みと加算はUpdateDocumentで行い、同じアカウントの同時書き込みでも加算を失わない
+func (p UsageRecorder) Record(ctx context.Context, accountID, channel string, n int, at time.Time) error {
+	for _, g := range []UsageGranularity{UsageHourly, UsageDaily} {
+		start := g.start(at)
+		key := usageKey(accountID, channel, g, start)
+
+		var v UsageRollup
+		err := UpdateDocument(ctx, p.Store, p.Collection, key, &v, func(exists bool) error {
+			if !exists {
+				v = UsageRollup{AccountID: accountID, Channel: channel, Granularity: g, Start: start}
+			}
+			v.Count += int64(n)
+			v.UpdatedAt = at
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Consume Subscribe.Consumeで使用回数を記録し、履歴にも加算します
+// Subscribe自体の保存は呼び出し側で行う
+func (p UsageRecorder) Consume(ctx context.Context, s *Subscribe, channel string, n uint16) error {
+	if err := s.Consume(channel, n); err != nil {
+		return err
+	}
+	return p.Record(ctx, s.ID, channel, int(n), s.now())
+}
+
+// Usage 期間内の使用回数を開始時刻順に返します
+// accountID, channelが空の場合は全て、from <= Start < to
+func (p UsageRecorder) Usage(ctx context.Context, accountID, channel string, g UsageGranularity, from, to time.Time) ([]UsageRollup, error) {
+	filters := []Filter{Where("granularity", g)}
+	if accountID != "" {
+		filters = append(filters, Where("account_id", accountID))
+	}
+	if channel != "" {
+		filters = append(filters, Where("channel", channel))
+	}
+
+	rows := []UsageRollup{}
+	err := Each(ctx, p.Store, p.Collection, filters, func(v UsageRollup) error {
+		if inRange(v.Start, from, to) {
+			rows = append(rows, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if !rows[i].Start.Equal(rows[j].Start) {
+			return rows[i].Start.Before(rows[j].Start)
+		}
+		if rows[i].AccountID != rows[j].AccountID {
+			return rows[i].AccountID < rows[j].AccountID
+		}
+		return rows[i].Channel < rows[j].Channel
+	})
+	return rows, nil
+}
+
+// UsageTotal is total usage of an account and a channel.
+type UsageTotal struct {
+	AccountID string `firestore:"account_id" json:"account_id" csv:"account_id"`
+	Channel   string `firestore:"channel" json:"channel" csv:"channel"`
+	Count     int64  `firestore:"count" json:"count" csv:"count"`
+}
+
+// TopConsumers 期間内の使用回数が多い順にアカウントを返します、日別の履歴を集計する
+// channelが空の場合は全てのチャンネル、limitが0以下の場合は全件
+func (p UsageRecorder) TopConsumers(ctx context.Context, channel string, from, to time.Time, limit int) ([]UsageTotal, error) {
+	rows, err := p.Usage(ctx, "", channel, UsageDaily, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[[2]string]int)
+	totals := []UsageTotal{}
+	for _, v := range rows {
+		key := [2]string{v.AccountID, v.Channel}
+		i, ok := index[key]
+		if !ok {
+			totals = append(totals, UsageTotal{AccountID: v.AccountID, Channel: v.Channel})
+			i = len(totals) - 1
+			index[key] = i
+		}
+		totals[i].Count += v.Count
+	}
+
+	sort.SliceStable(totals, func(i, j int) bool {
+		if totals[i].Count != totals[j].Count {
+			return totals[i].Count > totals[j].Count
+		}
+		return totals[i].AccountID < totals[j].AccountID
+	})
+	if limit > 0 && len(totals) > limit {
+		totals = totals[:limit]
+	}
+	return totals, nil
+}
+
+// LimitUsage is usage of a window against its limit.
+type LimitUsage struct {
+	AccountID string  `firestore:"account_id" json:"account_id" csv:"account_id"`
+	Channel   string  `firestore:"channel" json:"channel" csv:"channel"`
+	Window    string  `firestore:"window" json:"window" csv:"window"`
+	Used      uint16  `firestore:"used" json:"used" csv:"used"`
+	Limit     uint16  `firestore:"limit" json:"limit" csv:"limit"`
+	Ratio     float64 `firestore:"ratio" json:"ratio" csv:"ratio"`
+}
+
+// NearLimit 使用回数が使用制限のthreshold(0-1)以上の期間を、割合の高い順に返します
+// channelが空の場合は全てのチャンネル、使用制限が0の期間は対象外
+func NearLimit(subscribes []Subscribe, channel string, threshold float64, now time.Time) []LimitUsage {
+	rows := []LimitUsage{}
+	for _, s := range subscribes {
+		names := []string{channel}
+		if channel == "" {
+			names = []string{ChannelGUI, ChannelAPI}
+			for name := range s.Channels {
+				if name != ChannelGUI && name != ChannelAPI {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names[2:])
+		}
+
+		for _, name := range names {
+			if !s.HasChannel(name) {
+				continue
+			}
+			m := s.Channel(name)
+			used := QuotaStrategyOf(m.Strategy).Usage(m, now)
+			for _, w := range []struct {
+				name        string
+				used, limit uint16
+			}{
+				{"monthly", used.Monthly, m.Limit.Monthly},
+				{"daily", used.Daily, m.Limit.Daily},
+				{"hourly", used.Hourly, m.Limit.Hourly},
+			} {
+				if w.limit == 0 {
+					continue
+				}
+				ratio := float64(w.used) / float64(w.limit)
+				if ratio < threshold {
+					continue
+				}
+				rows = append(rows, LimitUsage{
+					AccountID: s.ID,
+					Channel:   name,
+					Window:    w.name,
+					Used:      w.used,
+					Limit:     w.limit,
+					Ratio:     ratio,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].Ratio > rows[j].Ratio
+	})
+	return rows
+}
+
+// WriteReportCSV レポートをCSVで書き出します、カラム名はcsvタグ名
+// `csv:"-"`のフィールドは出力しない
+func WriteReportCSV[T any](w io.Writer, rows []T) error {
+	schema := ExportSchemaOf(*new(T))
+	t := reflect.TypeOf(*new(T))
+	columns := []ExportColumn{}
+	for _, c := range schema.Columns {
+		tag := t.FieldByIndex(c.index[:1]).Tag.Get("csv")
+		if tag == "-" {
+			continue
+		}
+		if tag != "" && len(c.index) == 1 {
+			c.Name = tag
+		}
+		columns = append(columns, c)
+	}
+	schema.Columns = columns
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(schema.Names()); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		values := schema.values(row)
+		record := make([]string, len(values))
+		for i, v := range values {
+			switch v := v.(type) {
+			case nil:
+			case string:
+				record[i] = v
+			case int64:
+				record[i] = strconv.FormatInt(v, 10)
+			case float64:
+				record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+			case bool:
+				record[i] = strconv.FormatBool(v)
+			case time.Time:
+				record[i] = v.Format(time.RFC3339)
+			default:
+				data, _ := json.Marshal(v)
+				record[i] = string(data)
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteReportJSON レポートをJSONで書き出します
+func WriteReportJSON[T any](w io.Writer, rows []T) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rows)
+}
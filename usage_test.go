@@ -0,0 +1,34 @@
+package models
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageRecorderConcurrentRecord(t *testing.T) {
+	ctx := context.Background()
+	recorder := UsageRecorder{Store: NewMemoryStore(), Collection: "usages"}
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, recorder.Record(ctx, "acct", ChannelAPI, 1, at))
+		}()
+	}
+	wg.Wait()
+
+	for _, g := range []UsageGranularity{UsageHourly, UsageDaily} {
+		rows, err := recorder.Usage(ctx, "acct", ChannelAPI, g, at.Add(-24*time.Hour), at.Add(time.Hour))
+		require.NoError(t, err)
+		require.Len(t, rows, 1)
+		assert.Equal(t, int64(50), rows[0].Count, g)
+	}
+}
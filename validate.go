@@ -0,0 +1,61 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidationError は、フィールド単位の検証エラーを表します
+type ValidationError struct {
+	Field  string `json:"field"`
+	Value  string `json:"value,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Reason)
+}
+
+// ValidationErrors は、複数の検証エラーをまとめて返すために使用されます
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	s := make([]string, len(e))
+	for i, v := range e {
+		s[i] = v.Error()
+	}
+	return "validation failed: " + strings.Join(s, ", ")
+}
+
+// Has 指定したフィールドのエラーが含まれるかを確認
+func (e ValidationErrors) Has(field string) bool {
+	for _, v := range e {
+		if v.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Err エラーが無い場合はnilを返す
+// why: nilのValidationErrorsをerrorとして返すと、nil判定が効かないため
+func (e ValidationErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// ToHalfWidth 全角英数字・記号を半角に変換します
+// ０１２ -> 012, ＡＢＣ -> ABC, 全角スペース -> 半角スペース
+func ToHalfWidth(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '　':
+			return ' '
+		case r >= '！' && r <= '～':
+			return r - '！' + '!'
+		}
+		return r
+	}, s)
+}
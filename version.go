@@ -0,0 +1,306 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+var ErrVersionNotFound = errors.New("version not found")
+
+// VersionCollection is a name of subcollection for versions.
+// e.g. posts/{uuid}/versions
+const VersionCollection = "versions"
+
+// Version is a saved state of a document.
+// 保存の都度、保存後の内容と直前からの差分を記録する
+type Version[T any] struct {
+	Version int    `firestore:"version" json:"version"`
+	DocKey  string `firestore:"doc_key" json:"doc_key"`
+	// Author is a UserID or AccountID who saved, empty for the state before versioning.
+	Author string `firestore:"author,omitempty" json:"author,omitempty"`
+	// Changes is field-level diff from the previous version, by firestore tag name.
+	Changes []FieldChange `firestore:"changes,omitempty" json:"changes,omitempty"`
+	// RevertedFrom is a version number when saved by Revert.
+	RevertedFrom int `firestore:"reverted_from,omitempty" json:"reverted_from,omitempty"`
+
+	Doc T `firestore:"doc" json:"doc"`
+
+	CreatedAt time.Time `firestore:"created_at" json:"created_at"`
+}
+
+// VersionRetention is how long versions are kept, PlanDefinition.Versions.
+// 0は無制限、最新のバージョンは常に保持する
+type VersionRetention struct {
+	MaxVersions int           `firestore:"max_versions,omitempty" json:"max_versions,omitempty" yaml:"max_versions,omitempty"`
+	MaxAge      time.Duration `firestore:"max_age,omitempty" json:"max_age,omitempty" yaml:"max_age,omitempty"`
+}
+
+// DefaultVersionRetention is retention of a plan without Versions in the catalog, e.g. Unsubscribed.
+// 直前の状態のみ
+var DefaultVersionRetention = VersionRetention{MaxVersions: 2}
+
+// VersionHead is the latest version number of a document.
+// バージョン番号はUpdateDocumentで割り当て、同時に保存しても重複しない
+type VersionHead struct {
+	DocKey string `firestore:"doc_key" json:"doc_key"`
+	Latest int    `firestore:"latest" json:"latest"`
+}
+
+// Versioned saves documents with change history, for Post, Schedule and Rule.
+// 履歴を残さない既存の処理はStore.Setをそのまま使用できる
+type Versioned[T any] struct {
+	Store      Store
+	Collection string
+	// Catalogs is optional, DefaultPlanCatalogs if nil. 保持期間はPlanDefinition.Versions
+	Catalogs *PlanCatalogs
+}
+
+// versionCollection e.g. posts/{docKey}/versions
+func (p Versioned[T]) versionCollection(docKey string) string {
+	return fmt.Sprintf("%s/%s/%s", p.Collection, docKey, VersionCollection)
+}
+
+// headCollection e.g. posts_versions, キーはdocKey
+func (p Versioned[T]) headCollection() string {
+	return p.Collection + "_" + VersionCollection
+}
+
+func versionKey(n int) string {
+	return fmt.Sprintf("%08d", n)
+}
+
+// retention 購読プランのバージョンの保持期間を返します
+func (p Versioned[T]) retention(plan SubscribedPlan, at time.Time) VersionRetention {
+	catalogs := p.Catalogs
+	if catalogs == nil {
+		catalogs = DefaultPlanCatalogs()
+	}
+	return catalogs.Current(at).VersionRetention(plan)
+}
+
+// Save ドキュメントを保存し、新しいバージョンを記録します
+// 内容に変更が無い場合は保存せずnilを返す
+// 履歴の無い既存のドキュメントは、保存前の内容を作成者不明のバージョンとして先に記録する
+func (p Versioned[T]) Save(ctx context.Context, docKey string, doc T, author string, plan SubscribedPlan, at time.Time) (*Version[T], error) {
+	return p.save(ctx, docKey, doc, author, plan, at, 0)
+}
+
+// errVersionUnchanged 内容に変更が無い、ドキュメントを保存しない
+var errVersionUnchanged = errors.New("document is not changed")
+
+func (p Versioned[T]) save(ctx context.Context, docKey string, doc T, author string, plan SubscribedPlan, at time.Time, revertedFrom int) (*Version[T], error) {
+	history, err := p.History(ctx, docKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var prev T
+	var changes []FieldChange
+	exists := false
+	cur := new(T)
+	err = UpdateDocument(ctx, p.Store, p.Collection, docKey, cur, func(ok bool) error {
+		exists, prev = ok, *cur
+		changes = diffDocument(prev, doc)
+		if exists && len(changes) == 0 {
+			return errVersionUnchanged
+		}
+		*cur = doc
+		return nil
+	})
+	switch {
+	case errors.Is(err, errVersionUnchanged):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	// 履歴の無い既存のドキュメントは、1を保存前の内容に割り当てる
+	withBase := len(history) == 0 && exists
+	var head VersionHead
+	err = UpdateDocument(ctx, p.Store, p.headCollection(), docKey, &head, func(ok bool) error {
+		if !ok {
+			head = VersionHead{DocKey: docKey}
+			if len(history) > 0 {
+				head.Latest = history[0].Version
+			} else if withBase {
+				head.Latest = 1
+			}
+		}
+		head.Latest++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if withBase {
+		base := Version[T]{
+			Version:   1,
+			DocKey:    docKey,
+			Changes:   diffDocument(*new(T), prev),
+			Doc:       prev,
+			CreatedAt: at,
+		}
+		if err := CreateDocument(ctx, p.Store, p.versionCollection(docKey), versionKey(base.Version), base); err != nil && !errors.Is(err, ErrAlreadyExists) {
+			return nil, err
+		}
+		history = append(history, base)
+	}
+
+	v := Version[T]{
+		Version:      head.Latest,
+		DocKey:       docKey,
+		Author:       author,
+		Changes:      changes,
+		RevertedFrom: revertedFrom,
+		Doc:          doc,
+		CreatedAt:    at,
+	}
+	if err := CreateDocument(ctx, p.Store, p.versionCollection(docKey), versionKey(v.Version), v); err != nil {
+		return nil, err
+	}
+
+	history = append([]Version[T]{v}, history...)
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Version > history[j].Version
+	})
+	if err := p.prune(ctx, docKey, history, p.retention(plan, at), at); err != nil {
+		return &v, err
+	}
+
+	return &v, nil
+}
+
+// prune 保持期間を過ぎたバージョンを削除します、historyは新しい順
+func (p Versioned[T]) prune(ctx context.Context, docKey string, history []Version[T], retention VersionRetention, now time.Time) error {
+	for i, v := range history {
+		if i == 0 {
+			continue
+		}
+		expired := retention.MaxVersions > 0 && i >= retention.MaxVersions
+		if retention.MaxAge > 0 && now.Sub(v.CreatedAt) > retention.MaxAge {
+			expired = true
+		}
+		if !expired {
+			continue
+		}
+		if err := p.Store.Delete(ctx, p.versionCollection(docKey), versionKey(v.Version)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// History バージョンを新しい順に返します
+func (p Versioned[T]) History(ctx context.Context, docKey string) ([]Version[T], error) {
+	var history []Version[T]
+	if err := p.Store.List(ctx, p.versionCollection(docKey), nil, &history); err != nil {
+		return nil, err
+	}
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].Version > history[j].Version
+	})
+	return history, nil
+}
+
+// Version 指定したバージョンを返します
+func (p Versioned[T]) Version(ctx context.Context, docKey string, n int) (Version[T], error) {
+	var v Version[T]
+	if err := p.Store.Get(ctx, p.versionCollection(docKey), versionKey(n), &v); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return v, fmt.Errorf("%w: %s v%d", ErrVersionNotFound, docKey, n)
+		}
+		return v, err
+	}
+	return v, nil
+}
+
+// Revert 指定したバージョンの内容を新しいバージョンとして保存します
+// 履歴は書き換えないため、Revert自体も取り消せる
+func (p Versioned[T]) Revert(ctx context.Context, docKey string, n int, author string, plan SubscribedPlan, at time.Time) (*Version[T], error) {
+	v, err := p.Version(ctx, docKey, n)
+	if err != nil {
+		return nil, err
+	}
+	return p.save(ctx, docKey, v.Doc, author, plan, at, n)
+}
+
+// diffDocument firestoreタグ名ごとに値を比較します
+// 文字列はそのまま、それ以外はJSONとして差分に記録する
+func diffDocument(from, to any) []FieldChange {
+	a, _ := documentValue(reflect.ValueOf(from), false).(map[string]any)
+	b, _ := documentValue(reflect.ValueOf(to), false).(map[string]any)
+
+	names := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		names = append(names, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	changes := []FieldChange{}
+	for _, name := range names {
+		x, y := changeValue(truncateTimes(a[name])), changeValue(truncateTimes(b[name]))
+		if x == y {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: name, From: x, To: y})
+	}
+	return changes
+}
+
+// truncateTimes 時刻をFirestoreが保持する精度に丸めます
+// why: ナノ秒を含めて比較すると、Firestoreから読み込んだドキュメントとの差分に時刻が含まれるため
+func truncateTimes(v any) any {
+	switch v := v.(type) {
+	case time.Time:
+		return v.Truncate(auditTimePrecision)
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, x := range v {
+			m[k] = truncateTimes(x)
+		}
+		return m
+	case []any:
+		list := make([]any, len(v))
+		for i, x := range v {
+			list[i] = truncateTimes(x)
+		}
+		return list
+	}
+	return v
+}
+
+func changeValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format(time.RFC3339Nano)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	switch s := string(data); s {
+	case "0", "false", "[]", "{}", "null":
+		return ""
+	default:
+		return s
+	}
+}
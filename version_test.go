@@ -0,0 +1,63 @@
+package models
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionedConcurrentSave(t *testing.T) {
+	ctx := context.Background()
+	versioned := Versioned[Post]{Store: NewMemoryStore(), Collection: "posts"}
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := versioned.Save(ctx, "u1", Post{UUID: "u1", Priority: i + 1}, "test", SubscribedPro, at)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	history, err := versioned.History(ctx, "u1")
+	require.NoError(t, err)
+	require.Len(t, history, 20)
+	for i, v := range history {
+		assert.Equal(t, 20-i, v.Version)
+	}
+}
+
+func TestVersionedRetentionFromCatalog(t *testing.T) {
+	ctx := context.Background()
+	catalog := LegacyPlanCatalog()
+	catalog.Version = 2
+	catalog.Plans[SubscribedPro.String()] = PlanDefinition{Versions: &VersionRetention{MaxVersions: 3}}
+	catalogs, err := NewPlanCatalogs(LegacyPlanCatalog(), catalog)
+	require.NoError(t, err)
+
+	versioned := Versioned[Post]{Store: NewMemoryStore(), Collection: "posts", Catalogs: catalogs}
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		_, err := versioned.Save(ctx, "u1", Post{UUID: "u1", Priority: i + 1}, "test", SubscribedPro, at)
+		require.NoError(t, err)
+	}
+
+	history, err := versioned.History(ctx, "u1")
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.Equal(t, 5, history[0].Version)
+}
+
+func TestDiffDocumentTimePrecision(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	from := Post{Text: "a", CreatedAt: at}
+	to := Post{Text: "a", CreatedAt: at.Truncate(time.Microsecond)}
+	assert.Empty(t, diffDocument(from, to))
+}